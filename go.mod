@@ -2,8 +2,9 @@ module git-tools
 
 go 1.24.3
 
+require github.com/jroimartin/gocui v0.5.0
+
 require (
-	github.com/jroimartin/gocui v0.5.0 // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/nsf/termbox-go v1.1.1 // indirect
 )
@@ -0,0 +1,574 @@
+package gittools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The commit index lives in <git-common-dir>/git-tools/ and is an append-only file of
+// JSON lines, one CommitInfo per line. Later lines for the same hash override earlier
+// ones, which lets patch-ids be filled in lazily without rewriting the file.
+const (
+	cacheDirName   = "git-tools"
+	cacheIndexFile = "commits.v1.jsonl"
+	cacheLockFile  = "commits.lock"
+
+	cacheLockTimeout = 30 * time.Second
+	cacheLockStale   = 10 * time.Minute // age of an unreadable lock file taken as crashed
+)
+
+// CommitIndex is an on-disk cache of per-commit matching data keyed by commit hash
+type CommitIndex struct {
+	dir     string
+	entries map[string]*CommitInfo
+	size    int64       // bytes of the index file already loaded
+	file    os.FileInfo // identity of the loaded file, to notice rebuilds
+}
+
+// OpenCommitIndex loads the commit index of the current repository
+func OpenCommitIndex() (*CommitIndex, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	ix := &CommitIndex{dir: dir, entries: make(map[string]*CommitInfo)}
+	if err := ix.load(); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// newMemoryCommitIndex returns an index that is never written to disk, used when
+// the on-disk index is unavailable
+func newMemoryCommitIndex() *CommitIndex {
+	return &CommitIndex{entries: make(map[string]*CommitInfo)}
+}
+
+// openCommitIndexOrMemory opens the on-disk index, falling back to an in-memory one
+func openCommitIndexOrMemory() *CommitIndex {
+	ix, err := OpenCommitIndex()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: commit index unavailable, scanning history: %v\n", err)
+		return newMemoryCommitIndex()
+	}
+	return ix
+}
+
+// cacheDir returns the directory holding git-tools data, shared between worktrees
+func cacheDir() (string, error) {
+	output, err := exec.Command("git", "rev-parse", "--git-common-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate git directory: %v", err)
+	}
+	gitDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(gitDir) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		gitDir = filepath.Join(wd, gitDir)
+	}
+	return filepath.Join(gitDir, cacheDirName), nil
+}
+
+func (ix *CommitIndex) path() string {
+	return filepath.Join(ix.dir, cacheIndexFile)
+}
+
+// load reads entries appended to the index file since the last load
+func (ix *CommitIndex) load() error {
+	f, err := os.Open(ix.path())
+	if os.IsNotExist(err) {
+		ix.size = 0
+		ix.file = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open commit index: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if ix.file != nil && (!os.SameFile(ix.file, info) || info.Size() < ix.size) {
+		// The index was rebuilt or cleared by another process
+		ix.entries = make(map[string]*CommitInfo)
+		ix.size = 0
+	}
+	ix.file = info
+	if _, err := f.Seek(ix.size, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Writers hold the lock, so a partial trailing line was left by a crashed
+			// write; append truncates it
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read commit index: %v", err)
+		}
+		ix.size += int64(len(line))
+		var entry CommitInfo
+		if json.Unmarshal(line, &entry) != nil || entry.Hash == "" {
+			continue // skip corrupted lines rather than failing the whole run
+		}
+		ix.entries[entry.Hash] = &entry
+	}
+}
+
+// Get returns the cached data for a commit
+func (ix *CommitIndex) Get(hash string) (*CommitInfo, bool) {
+	entry, ok := ix.entries[hash]
+	return entry, ok
+}
+
+// Len returns the number of commits in the index
+func (ix *CommitIndex) Len() int {
+	return len(ix.entries)
+}
+
+// Update indexes every commit reachable from revs that is not cached yet and returns
+// the hashes of all commits reachable from revs, newest first. revs are passed to
+// git rev-list as-is, so ranges and options like --branches work.
+func (ix *CommitIndex) Update(revs ...string) ([]string, error) {
+	hashes, err := revList(revs...)
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, hash := range hashes {
+		if _, ok := ix.entries[hash]; !ok {
+			unknown = append(unknown, hash)
+		}
+	}
+	if len(unknown) == 0 {
+		return hashes, nil
+	}
+
+	entries, err := readCommitInfo(unknown)
+	if err != nil {
+		return nil, err
+	}
+	if err := ix.append(entries); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// EnsurePatchIDs computes and stores the patch-id of every given commit lacking one
+func (ix *CommitIndex) EnsurePatchIDs(hashes []string) error {
+	var pending []string
+	for _, hash := range hashes {
+		if entry, ok := ix.entries[hash]; !ok || entry.PatchID == nil {
+			pending = append(pending, hash)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if missing := ix.missing(pending); len(missing) > 0 {
+		entries, err := readCommitInfo(missing)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			ix.entries[entry.Hash] = entry
+		}
+	}
+
+	patchIDs, err := readPatchIDs(pending)
+	if err != nil {
+		return err
+	}
+	updated := make([]*CommitInfo, 0, len(pending))
+	for _, hash := range pending {
+		cached, ok := ix.entries[hash]
+		if !ok {
+			return fmt.Errorf("commit %s not found", hash)
+		}
+		entry := *cached
+		patchID := patchIDs[hash]
+		entry.PatchID = &patchID
+		updated = append(updated, &entry)
+	}
+	return ix.append(updated)
+}
+
+func (ix *CommitIndex) missing(hashes []string) []string {
+	var missing []string
+	for _, hash := range hashes {
+		if _, ok := ix.entries[hash]; !ok {
+			missing = append(missing, hash)
+		}
+	}
+	return missing
+}
+
+// append writes entries to the index file under the cache lock
+func (ix *CommitIndex) append(entries []*CommitInfo) error {
+	if ix.dir == "" {
+		for _, entry := range entries {
+			ix.entries[entry.Hash] = entry
+		}
+		return nil
+	}
+	unlock, err := ix.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Pick up whatever concurrent invocations appended meanwhile
+	if err := ix.load(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if existing, ok := ix.entries[entry.Hash]; ok && (existing.PatchID != nil || entry.PatchID == nil) {
+			continue // already written by a concurrent invocation
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(ix.path(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open commit index for writing: %v", err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > ix.size {
+		// Drop the partial line of a crashed write rather than gluing entries onto it
+		if err := f.Truncate(ix.size); err != nil {
+			return fmt.Errorf("failed to repair commit index: %v", err)
+		}
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write commit index: %v", err)
+	}
+
+	ix.size += int64(buf.Len())
+	for _, entry := range entries {
+		if existing, ok := ix.entries[entry.Hash]; !ok || existing.PatchID == nil {
+			ix.entries[entry.Hash] = entry
+		}
+	}
+	return nil
+}
+
+// Rebuild discards the index and re-indexes every commit reachable from revs,
+// including patch-ids
+func (ix *CommitIndex) Rebuild(revs ...string) error {
+	if err := ix.Clear(); err != nil {
+		return err
+	}
+	hashes, err := ix.Update(revs...)
+	if err != nil {
+		return err
+	}
+	return ix.EnsurePatchIDs(hashes)
+}
+
+// Clear removes the index file
+func (ix *CommitIndex) Clear() error {
+	unlock, err := ix.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(ix.path()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove commit index: %v", err)
+	}
+	ix.entries = make(map[string]*CommitInfo)
+	ix.size = 0
+	ix.file = nil
+	return nil
+}
+
+// lock takes the cache lock file, waiting for other git-tools processes to release it
+func (ix *CommitIndex) lock() (func(), error) {
+	return lockCache(ix.dir)
+}
+
+// lockCache takes the lock file of the cache directory dir, which serializes the
+// writers of every file in it
+func lockCache(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	lockPath := filepath.Join(dir, cacheLockFile)
+	// The PID identifies a dead holder; the time tells this lock from a later one
+	// taken by the same process
+	token := fmt.Sprintf("%d %d\n", os.Getpid(), time.Now().UnixNano())
+	deadline := time.Now().Add(cacheLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			fmt.Fprint(f, token)
+			f.Close()
+			return func() { releaseLock(lockPath, token) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create cache lock: %v", err)
+		}
+		if breakStaleLock(lockPath) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for cache lock %s", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// releaseLock removes the lock file if it is still the one taken with token
+func releaseLock(lockPath, token string) {
+	if data, err := os.ReadFile(lockPath); err == nil && string(data) == token {
+		os.Remove(lockPath)
+	}
+}
+
+// breakStaleLock removes a lock file left behind by a process that died. Waiters
+// break it one at a time, under a second lock file, and check it again there so
+// that a lock taken by another waiter meanwhile is kept.
+func breakStaleLock(lockPath string) bool {
+	if !lockIsStale(lockPath) {
+		return false
+	}
+	guard := lockPath + ".break"
+	f, err := os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		// The guard is only held for a moment, unless its holder crashed
+		if info, statErr := os.Stat(guard); statErr == nil && time.Since(info.ModTime()) > cacheLockStale {
+			os.Remove(guard)
+		}
+		return false
+	}
+	f.Close()
+	defer os.Remove(guard)
+	return lockIsStale(lockPath) && os.Remove(lockPath) == nil
+}
+
+// lockIsStale reports whether the process that took the lock file has died
+func lockIsStale(lockPath string) bool {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+	var pid int
+	if _, err := fmt.Sscan(string(data), &pid); err != nil || pid <= 0 {
+		// The holder may not have written its PID yet, unless it crashed doing so
+		return time.Since(info.ModTime()) > cacheLockStale
+	}
+	return !processAlive(pid)
+}
+
+// processAlive reports whether a process with the PID is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true // FindProcess fails there for processes that exited
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// revList returns the commits reachable from revs, newest first
+func revList(revs ...string) ([]string, error) {
+	cmd := exec.Command("git", append([]string{"rev-list"}, revs...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// readCommitInfo reads subjects and trailers of the given commits in one git invocation
+func readCommitInfo(hashes []string) ([]*CommitInfo, error) {
+	cmd := exec.Command("git", "log", "--no-walk=unsorted", "--stdin",
+		"--pretty=format:%H"+LogDelimiter+"%s"+LogDelimiter+"%B"+RecordDelimiter)
+	cmd.Stdin = strings.NewReader(strings.Join(hashes, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commits: %v", err)
+	}
+
+	entries := make([]*CommitInfo, 0, len(hashes))
+	for _, record := range strings.Split(string(output), RecordDelimiter) {
+		parts := strings.SplitN(strings.TrimLeft(record, "\n"), LogDelimiter, 3)
+		if len(parts) < 3 {
+			continue
+		}
+		trailers := ParseTrailers(parts[2])
+		entries = append(entries, &CommitInfo{
+			Hash:     parts[0],
+			Subject:  NormalizeSubject(parts[1]),
+			ChangeID: TrailerValue(trailers, "Change-Id"),
+			Trailers: trailers,
+		})
+	}
+	return entries, nil
+}
+
+// readPatchIDs computes stable patch-ids for the given commits. Commits without a
+// patch (merges, empty commits) are absent from the result.
+func readPatchIDs(hashes []string) (map[string]string, error) {
+	logCmd := exec.Command("git", "log", "--no-walk=unsorted", "--stdin", "-p", "--pretty=format:commit %H")
+	logCmd.Stdin = strings.NewReader(strings.Join(hashes, "\n") + "\n")
+	patches, err := logCmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	patchIDCmd := exec.Command("git", "patch-id", "--stable")
+	patchIDCmd.Stdin = patches
+	var output bytes.Buffer
+	patchIDCmd.Stdout = &output
+
+	if err := logCmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to read patches: %v", err)
+	}
+	if err := patchIDCmd.Run(); err != nil {
+		logCmd.Wait()
+		return nil, fmt.Errorf("failed to compute patch-ids: %v", err)
+	}
+	if err := logCmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to read patches: %v", err)
+	}
+
+	patchIDs := make(map[string]string, len(hashes))
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			patchIDs[fields[1]] = fields[0]
+		}
+	}
+	return patchIDs, scanner.Err()
+}
+
+// CacheStats describes the contents of the commit index
+type CacheStats struct {
+	Path        string
+	Commits     int
+	WithPatchID int
+	WithChange  int
+	FileBytes   int64
+}
+
+// Stats summarizes the index
+func (ix *CommitIndex) Stats() CacheStats {
+	stats := CacheStats{Path: ix.path(), Commits: len(ix.entries)}
+	for _, entry := range ix.entries {
+		if entry.PatchID != nil {
+			stats.WithPatchID++
+		}
+		if entry.ChangeID != "" {
+			stats.WithChange++
+		}
+	}
+	if info, err := os.Stat(ix.path()); err == nil {
+		stats.FileBytes = info.Size()
+	}
+	return stats
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "cache",
+		Args:    "rebuild [<rev>...] | stats | clear",
+		Summary: "Manage the commit index in .git/git-tools/",
+		Description: `rebuild  Discard the index and re-index <rev>s (default: all branches and remotes)
+stats    Show the index location and size
+clear    Delete the index`,
+		Run: func(cmd *Command, args []string) int {
+			if len(args) == 0 {
+				return cmd.UsageError("missing cache action")
+			}
+			switch args[0] {
+			case "rebuild":
+			case "stats", "clear":
+				if len(args) > 1 {
+					return cmd.UsageError("%s takes no arguments", args[0])
+				}
+			default:
+				return cmd.UsageError("unknown cache action: %s", args[0])
+			}
+			return RunCache(args[0], args[1:])
+		},
+	})
+}
+
+// RunCache implements the cache subcommand and returns the exit code
+func RunCache(action string, revs []string) int {
+	if !IsGitRepo() {
+		fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
+		return ExitError
+	}
+
+	ix, err := OpenCommitIndex()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening commit index: %v\n", err)
+		return ExitError
+	}
+
+	switch action {
+	case "rebuild":
+		if len(revs) == 0 {
+			revs = []string{"--branches", "--remotes"}
+		}
+		start := time.Now()
+		if err := ix.Rebuild(revs...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rebuilding commit index: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Indexed %d commit(s) in %s\n", ix.Len(), time.Since(start).Round(time.Millisecond))
+	case "stats":
+		stats := ix.Stats()
+		fmt.Printf("Index:       %s\n", stats.Path)
+		fmt.Printf("Commits:     %d\n", stats.Commits)
+		fmt.Printf("Patch-ids:   %d\n", stats.WithPatchID)
+		fmt.Printf("Change-Ids:  %d\n", stats.WithChange)
+		fmt.Printf("Size:        %d bytes\n", stats.FileBytes)
+	case "clear":
+		if err := ix.Clear(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error clearing commit index: %v\n", err)
+			return ExitError
+		}
+		fmt.Println("Commit index cleared")
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cache action: %s\n", action)
+		return ExitError
+	}
+	return ExitOK
+}
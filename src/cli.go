@@ -0,0 +1,391 @@
+package gittools
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Exit codes shared by all subcommands
+const (
+	ExitOK    = 0
+	ExitError = 1 // the command failed
+	ExitUsage = 2 // invalid command line (129 when run as a git subcommand)
+)
+
+// Version is set at build time with -ldflags "-X git-tools/src.Version=..."
+var Version = "dev"
+
+// Command describes a subcommand. Subcommands register themselves from an init
+// function in their own file with RegisterCommand.
+type Command struct {
+	Name        string
+	Args        string // synopsis of the positional arguments, e.g. "<branch1> <branch2>"
+	Summary     string // one line shown in the command list
+	Description string // longer text shown by "help <command>"
+	// Flags registers the command's options. Commands without options receive their
+	// arguments unparsed, apart from -h/--help.
+	Flags func(fs *FlagSet)
+	// Run executes the command with its positional arguments and returns the exit code
+	Run func(cmd *Command, args []string) int
+	// GitCommand marks commands that can also run as "git <name>" (see install-aliases)
+	GitCommand bool
+
+	flags *FlagSet // options of the current invocation
+	args  []string // unparsed arguments of the current invocation
+}
+
+var commands = make(map[string]*Command)
+
+// RegisterCommand makes a subcommand available to RunCLI
+func RegisterCommand(cmd *Command) {
+	if _, ok := commands[cmd.Name]; ok {
+		panic("duplicate command " + cmd.Name)
+	}
+	commands[cmd.Name] = cmd
+}
+
+// lookupCommand returns the registered command with the given name
+func lookupCommand(name string) (*Command, bool) {
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// sortedCommands returns all registered commands ordered by name
+func sortedCommands() []*Command {
+	list := make([]*Command, 0, len(commands))
+	for _, cmd := range commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// newFlagSet returns the command's flag set with all its options registered
+func (c *Command) newFlagSet() *FlagSet {
+	fs := newFlagSet(c.Name)
+	if c.Flags != nil {
+		c.Flags(fs)
+	}
+	return fs
+}
+
+// Execute parses args and runs the command
+func (c *Command) Execute(args []string) int {
+	if c.Flags == nil {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+			c.PrintHelp(os.Stdout)
+			return helpExitCode()
+		}
+		return c.Run(c, args)
+	}
+	fs := c.newFlagSet()
+	c.flags = fs
+	c.args = args
+	positional, err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		c.PrintHelp(os.Stdout)
+		return helpExitCode()
+	}
+	if err != nil {
+		return c.UsageError("%v", err)
+	}
+	return c.Run(c, positional)
+}
+
+// Changed reports whether an option was given on the command line, so that it
+// can take precedence over configuration
+func (c *Command) Changed(long string) bool {
+	return c.flags != nil && c.flags.Changed(long)
+}
+
+// ArgsWithout returns the command line of the current invocation without the given
+// options and their values, e.g. to run the command again in another directory
+func (c *Command) ArgsWithout(longs ...string) []string {
+	if c.flags == nil {
+		return c.args
+	}
+	return c.flags.strip(c.args, longs)
+}
+
+// UsageError reports an invalid command line and returns the usage exit code
+func (c *Command) UsageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "error: %s\n", fmt.Sprintf(format, args...))
+	fmt.Fprintf(os.Stderr, "usage: %s\n", c.synopsis())
+	if gitMode {
+		fmt.Fprintf(os.Stderr, "Run '%s -h' for details.\n", c.invocation())
+	} else {
+		fmt.Fprintf(os.Stderr, "Run '%s help %s' for details.\n", programName(), c.Name)
+	}
+	return usageExitCode()
+}
+
+// invocation is how the command is typed: "git-tools <name>" or "git <name>"
+func (c *Command) invocation() string {
+	return programName() + " " + c.Name
+}
+
+func (c *Command) synopsis() string {
+	parts := []string{c.invocation()}
+	if c.Flags != nil {
+		parts = append(parts, "[<options>]")
+	}
+	if c.Args != "" {
+		parts = append(parts, c.Args)
+	}
+	return strings.Join(parts, " ")
+}
+
+// PrintHelp writes the full help text of the command
+func (c *Command) PrintHelp(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s\n\n", c.synopsis())
+	fmt.Fprintf(w, "%s\n", c.Summary)
+	if c.Description != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.Description))
+	}
+	fs := c.newFlagSet()
+	if len(fs.order) > 0 {
+		fmt.Fprintf(w, "\nOptions:\n")
+		fs.PrintDefaults(w)
+	}
+}
+
+// gitMode is set when the binary runs as a git subcommand, e.g. installed as
+// git-find-missing and invoked as "git find-missing"
+var gitMode bool
+
+// programName is the name the tool is invoked as in help output
+func programName() string {
+	if gitMode {
+		return "git"
+	}
+	return "git-tools"
+}
+
+// usageExitCode follows git's convention of exiting with 129 on usage errors when
+// running as a git subcommand
+func usageExitCode() int {
+	if gitMode {
+		return 129
+	}
+	return ExitUsage
+}
+
+// helpExitCode is the exit code after printing help requested with -h
+func helpExitCode() int {
+	if gitMode {
+		return 129
+	}
+	return ExitOK
+}
+
+// FlagSet wraps flag.FlagSet with long/short option aliases, help output in
+// "-s, --long" style and option parsing interspersed with positional arguments
+type FlagSet struct {
+	flags  *flag.FlagSet
+	order  []*option
+	byName map[string]*option
+}
+
+type option struct {
+	long, short string
+	aliases     []string // additional long names
+	placeholder string   // empty for boolean options
+	usage       string
+	defValue    string
+}
+
+func newFlagSet(name string) *FlagSet {
+	fs := &FlagSet{
+		flags:  flag.NewFlagSet(name, flag.ContinueOnError),
+		byName: make(map[string]*option),
+	}
+	fs.flags.SetOutput(io.Discard)
+	fs.flags.Usage = func() {}
+	return fs
+}
+
+func (fs *FlagSet) add(opt *option, register func(name string)) {
+	register(opt.long)
+	if opt.short != "" {
+		register(opt.short)
+		fs.byName[opt.short] = opt
+	}
+	fs.byName[opt.long] = opt
+	fs.order = append(fs.order, opt)
+}
+
+// BoolVar defines a boolean option; short may be empty
+func (fs *FlagSet) BoolVar(p *bool, long, short string, usage string) {
+	fs.add(&option{long: long, short: short, usage: usage}, func(name string) {
+		fs.flags.BoolVar(p, name, *p, usage)
+	})
+}
+
+// StringVar defines a string option taking a value named placeholder in help output
+func (fs *FlagSet) StringVar(p *string, long, short, placeholder, usage string) {
+	fs.add(&option{long: long, short: short, placeholder: placeholder, usage: usage, defValue: *p}, func(name string) {
+		fs.flags.StringVar(p, name, *p, usage)
+	})
+}
+
+// IntVar defines an integer option
+func (fs *FlagSet) IntVar(p *int, long, short, placeholder, usage string) {
+	def := ""
+	if *p != 0 {
+		def = fmt.Sprint(*p)
+	}
+	fs.add(&option{long: long, short: short, placeholder: placeholder, usage: usage, defValue: def}, func(name string) {
+		fs.flags.IntVar(p, name, *p, usage)
+	})
+}
+
+// Var defines an option with a custom flag.Value, e.g. a repeatable option
+func (fs *FlagSet) Var(value flag.Value, long, short, placeholder, usage string) {
+	fs.add(&option{long: long, short: short, placeholder: placeholder, usage: usage}, func(name string) {
+		fs.flags.Var(value, name, usage)
+	})
+}
+
+// Alias registers another long name for an existing option
+func (fs *FlagSet) Alias(alias, long string) {
+	opt := fs.byName[long]
+	fs.flags.Var(fs.flags.Lookup(long).Value, alias, opt.usage)
+	opt.aliases = append(opt.aliases, alias)
+	fs.byName[alias] = opt
+}
+
+// Changed reports whether an option was given on the command line
+func (fs *FlagSet) Changed(long string) bool {
+	opt, ok := fs.byName[long]
+	if !ok {
+		return false
+	}
+	changed := false
+	fs.flags.Visit(func(f *flag.Flag) {
+		if fs.byName[f.Name] == opt {
+			changed = true
+		}
+	})
+	return changed
+}
+
+// Parse parses options anywhere on the command line and returns the positional
+// arguments. Everything after a "--" separator is positional.
+func (fs *FlagSet) Parse(args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.flags.Parse(args); err != nil {
+			return nil, describeFlagError(err)
+		}
+		rest := fs.flags.Args()
+		consumed := len(args) - len(rest)
+		if consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// strip removes the given options, with their values, from an argument list
+func (fs *FlagSet) strip(args []string, longs []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(kept, args[i:]...)
+		}
+		name, hasValue := strings.TrimLeft(arg, "-"), false
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			name, hasValue = name[:eq], true
+		}
+		opt, ok := fs.byName[name]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || !ok {
+			kept = append(kept, arg)
+			continue
+		}
+		takesValue := opt.placeholder != "" && !hasValue && i+1 < len(args)
+		if containsString(longs, opt.long) {
+			if takesValue {
+				i++
+			}
+			continue
+		}
+		kept = append(kept, arg)
+		if takesValue {
+			i++
+			kept = append(kept, args[i])
+		}
+	}
+	return kept
+}
+
+// describeFlagError rewords flag package errors using the "--long" option syntax
+func describeFlagError(err error) error {
+	msg := err.Error()
+	for prefix, format := range map[string]string{
+		"flag provided but not defined: -": "unknown option: %s",
+		"flag needs an argument: -":        "option %s needs a value",
+	} {
+		if name, ok := strings.CutPrefix(msg, prefix); ok {
+			return fmt.Errorf(format, optionName(name))
+		}
+	}
+	return err
+}
+
+// optionName formats an option name the way it is written on the command line
+func optionName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// PrintDefaults writes the option list in "-s, --long <value>  usage" form
+func (fs *FlagSet) PrintDefaults(w io.Writer) {
+	names := make([]string, len(fs.order))
+	width := 0
+	for i, opt := range fs.order {
+		name := "    --" + opt.long
+		if opt.short != "" {
+			name = "-" + opt.short + ", --" + opt.long
+		}
+		for _, alias := range opt.aliases {
+			name += ", --" + alias
+		}
+		if opt.placeholder != "" {
+			name += " <" + opt.placeholder + ">"
+		}
+		names[i] = name
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	for i, opt := range fs.order {
+		usage := opt.usage
+		if opt.defValue != "" {
+			usage += fmt.Sprintf(" (default %q)", opt.defValue)
+		}
+		fmt.Fprintf(w, "  %-*s  %s\n", width, names[i], usage)
+	}
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable option
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
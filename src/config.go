@@ -0,0 +1,452 @@
+package gittools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Configuration is read from the [git-tools] section of git config and from an
+// optional .git-tools.toml committed at the top of the repository. Keys in
+// subsections ([git-tools "tui"] in git config, [tui] in TOML) are addressed as
+// "tui.theme". From lowest to highest precedence the sources are:
+//
+//	built-in defaults < system < global < .git-tools.toml < local < worktree < git -c
+//
+// Command-line options override all of them. A key takes all of its values from
+// the highest-precedence source that sets it; lists are not merged across sources.
+
+// ConfigFileName is the repository configuration file at the top of the worktree
+const ConfigFileName = ".git-tools.toml"
+
+// configSection is the git config section holding git-tools settings
+const configSection = "git-tools"
+
+// Precedence of each configuration source, higher wins
+var configScopeRank = map[string]int{
+	"default":  0,
+	"system":   1,
+	"global":   2,
+	"toml":     3,
+	"local":    4,
+	"worktree": 5,
+	"command":  6,
+}
+
+// ConfigKey documents a known configuration key
+type ConfigKey struct {
+	Name        string // canonical spelling, e.g. "defaultTarget"
+	Default     string
+	Description string
+}
+
+// configKeys lists the keys shown by "config show"; other keys are still loaded
+var configKeys = []ConfigKey{
+	{"match", "subject", "find-missing matching strategies: subject, patch-id, change-id, cherry-pick, trailer"},
+	{"trailer", "", "Trailer keys whose value names the original commit, used by the trailer strategy"},
+	{"ignore", "", "Regexes (one per value); branch1 commits with a matching subject are never reported"},
+	{"defaultTarget", "", "branch2 used by find-missing when only one branch is given"},
+	{"sinceMergeBase", "false", "Only match against branch2 commits after the merge-base"},
+	{"format", "text", "find-missing output format: text or json"},
+	{"releaseTags", "v*", "Globs of tags marking releases, for grep-branch --first-release"},
+	{"releaseBranches", "release/*", "Globs of release branches, for grep-branch --first-release"},
+	{"tui.wordDiff", "true", "Highlight the changed words of modified lines in TUI patches"},
+	{"tui.syntax", "true", "Color code by language in TUI patches"},
+	{"tui.theme", "dark", "TUI colors: dark, light or high-contrast; theme.<role> overrides a style"},
+}
+
+// ConfigValue is the effective value of a key and where it came from
+type ConfigValue struct {
+	Values []string
+	Scope  string // default, system, global, toml, local, worktree or command
+	Origin string // file the value was read from, if any
+}
+
+// Source describes where the value was set
+func (v ConfigValue) Source() string {
+	if v.Origin == "" {
+		return v.Scope
+	}
+	return v.Scope + " (" + v.Origin + ")"
+}
+
+// Config holds the effective configuration, keyed by lower-cased key name
+type Config struct {
+	values map[string]ConfigValue
+}
+
+// LoadConfig reads the configuration of the current repository
+func LoadConfig() (*Config, error) {
+	cfg := &Config{values: make(map[string]ConfigValue)}
+	for _, key := range configKeys {
+		if key.Default != "" {
+			cfg.set(key.Name, key.Default, "default", "")
+		}
+	}
+	if err := cfg.loadTOML(); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadGitConfig(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// set adds a value for key from scope, replacing values from lower-precedence scopes
+func (c *Config) set(key, value, scope, origin string) {
+	key = strings.ToLower(key)
+	current, ok := c.values[key]
+	switch {
+	case !ok || configScopeRank[scope] > configScopeRank[current.Scope]:
+		c.values[key] = ConfigValue{Values: []string{value}, Scope: scope, Origin: origin}
+	case scope == current.Scope:
+		current.Values = append(current.Values, value)
+		if current.Origin != origin {
+			current.Origin = "" // e.g. a system value set in several included files
+		}
+		c.values[key] = current
+	}
+}
+
+// loadGitConfig reads the git-tools section of every git config scope
+func (c *Config) loadGitConfig() error {
+	cmd := exec.Command("git", "config", "--show-origin", "--show-scope", "-z", "--get-regexp", `^`+configSection+`\.`)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil // no git-tools keys set
+		}
+		return fmt.Errorf("failed to read git config: %v", err)
+	}
+
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		scope, origin, entry := fields[i], fields[i+1], fields[i+2]
+		key, value, hasValue := strings.Cut(entry, "\n")
+		if !hasValue {
+			value = "true" // "[git-tools] key" without a value is a boolean
+		}
+		origin = strings.TrimPrefix(origin, "file:")
+		c.set(strings.TrimPrefix(key, configSection+"."), value, scope, origin)
+	}
+	return nil
+}
+
+// loadTOML reads .git-tools.toml from the top of the worktree, if present
+func (c *Config) loadTOML() error {
+	output, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil // bare repository or not in a worktree
+	}
+	path := filepath.Join(strings.TrimSpace(string(output)), ConfigFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", ConfigFileName, err)
+	}
+
+	entries, err := parseTOML(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %v", ConfigFileName, err)
+	}
+	for _, entry := range entries {
+		for _, value := range entry.values {
+			c.set(entry.key, value, "toml", ConfigFileName)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the effective value of key
+func (c *Config) Lookup(key string) (ConfigValue, bool) {
+	v, ok := c.values[strings.ToLower(key)]
+	return v, ok
+}
+
+// Get returns the last value of key, as git does for single-valued keys
+func (c *Config) Get(key string) string {
+	if v, ok := c.Lookup(key); ok && len(v.Values) > 0 {
+		return v.Values[len(v.Values)-1]
+	}
+	return ""
+}
+
+// List returns all values of key, splitting comma-separated values
+func (c *Config) List(key string) []string {
+	return splitList(c.All(key))
+}
+
+// All returns all values of key as given, for values that may contain commas
+func (c *Config) All(key string) []string {
+	v, ok := c.Lookup(key)
+	if !ok {
+		return nil
+	}
+	return v.Values
+}
+
+// Bool returns key interpreted as a git boolean
+func (c *Config) Bool(key string) (bool, error) {
+	value := c.Get(key)
+	switch strings.ToLower(value) {
+	case "", "false", "no", "off", "0":
+		return false, nil
+	case "true", "yes", "on", "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("bad boolean value '%s' for %s", value, key)
+}
+
+// Keys returns every key that has a value, sorted
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitList splits comma-separated values and drops empty items
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+type tomlEntry struct {
+	key    string
+	values []string
+}
+
+// parseTOML parses the subset of TOML used by .git-tools.toml: [table] headers and
+// key = value pairs whose values are strings, booleans, integers or arrays of those.
+// Keys inside a table are returned as "table.key".
+func parseTOML(data string) ([]tomlEntry, error) {
+	var entries []tomlEntry
+	table := ""
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: unsupported table header", lineNo)
+			}
+			table = strings.Trim(strings.TrimSpace(line[1:len(line)-1]), `"`)
+			table = strings.TrimPrefix(table, configSection+".")
+			if table == configSection {
+				table = ""
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		raw = strings.TrimSpace(raw)
+		// Arrays may span several lines
+		for strings.HasPrefix(raw, "[") && !tomlArrayClosed(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+
+		values, err := parseTOMLValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		entries = append(entries, tomlEntry{key: key, values: values})
+	}
+	return entries, nil
+}
+
+// stripTOMLComment removes a trailing # comment that is not inside a string
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote && (quote == '\'' || i == 0 || line[i-1] != '\\') {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func tomlArrayClosed(raw string) bool {
+	return strings.HasSuffix(strings.TrimSpace(stripTOMLComment(raw)), "]")
+}
+
+// parseTOMLValue returns the values of a scalar or an array
+func parseTOMLValue(raw string) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		value, rest, err := parseTOMLScalar(raw)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected text after value: %s", rest)
+		}
+		return []string{value}, nil
+	}
+
+	var values []string
+	rest := strings.TrimSpace(raw[1:])
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		if strings.HasPrefix(rest, "]") {
+			if strings.TrimSpace(rest[1:]) != "" {
+				return nil, fmt.Errorf("unexpected text after array")
+			}
+			return values, nil
+		}
+		if rest == "" {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		value, remaining, err := parseTOMLScalar(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		rest = strings.TrimSpace(remaining)
+	}
+}
+
+// parseTOMLScalar parses a string, boolean or number at the start of raw and
+// returns it with the unparsed remainder
+func parseTOMLScalar(raw string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '\\' {
+				i++
+				continue
+			}
+			if raw[i] == '"' {
+				value, err := strconv.Unquote(raw[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", raw[:i+1])
+				}
+				return value, raw[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return raw[1 : end+1], raw[end+2:], nil
+	}
+
+	end := strings.IndexAny(raw, ", ]")
+	if end < 0 {
+		end = len(raw)
+	}
+	token := raw[:end]
+	if token == "true" || token == "false" {
+		return token, raw[end:], nil
+	}
+	if _, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return token, raw[end:], nil
+	}
+	return "", "", fmt.Errorf("unsupported value %q", token)
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "config",
+		Args:    "show",
+		Summary: "Show the effective configuration and where each value comes from",
+		Description: `Settings are read from the [git-tools] section of git config (system, global,
+local) and from a ` + ConfigFileName + ` file at the top of the repository.
+Precedence, lowest first: defaults, system, global, ` + ConfigFileName + `, local,
+worktree, git -c. Command-line options override all of them.
+
+Keys:
+` + describeConfigKeys(),
+		Run: func(cmd *Command, args []string) int {
+			if len(args) != 1 || args[0] != "show" {
+				return cmd.UsageError("expected 'show'")
+			}
+			if !IsGitRepo() {
+				fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
+				return ExitError
+			}
+			cfg, err := LoadConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return ExitError
+			}
+			ShowConfig(cfg)
+			return ExitOK
+		},
+	})
+}
+
+// describeConfigKeys lists the known keys for help output
+func describeConfigKeys() string {
+	var b strings.Builder
+	for _, key := range configKeys {
+		fmt.Fprintf(&b, "  %-15s %s\n", key.Name, key.Description)
+	}
+	return b.String()
+}
+
+// ShowConfig prints every known key and any other key that is set, with its source
+func ShowConfig(cfg *Config) {
+	type row struct{ key, value, source string }
+	var rows []row
+	known := make(map[string]bool)
+	for _, key := range configKeys {
+		known[strings.ToLower(key.Name)] = true
+		v, ok := cfg.Lookup(key.Name)
+		if !ok {
+			rows = append(rows, row{key.Name, "", "unset"})
+			continue
+		}
+		rows = append(rows, row{key.Name, strings.Join(v.Values, ", "), v.Source()})
+	}
+	for _, key := range cfg.Keys() {
+		if known[key] {
+			continue
+		}
+		v, _ := cfg.Lookup(key)
+		rows = append(rows, row{key, strings.Join(v.Values, ", "), v.Source()})
+	}
+
+	keyWidth, valueWidth := 0, 0
+	for _, r := range rows {
+		keyWidth = max(keyWidth, len(r.key))
+		valueWidth = max(valueWidth, len(r.value))
+	}
+	for _, r := range rows {
+		fmt.Printf("%s%-*s%s  %-*s  %s%s%s\n", ColorYellow, keyWidth, r.key, ColorReset,
+			valueWidth, r.value, ColorCyan, r.source, ColorReset)
+	}
+}
@@ -2,77 +2,338 @@ package gittools
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
 	"sort"
 	"strings"
 )
 
-func FindMissing(branch1, branch2 string) {
-	FindMissingWithOptions(branch1, branch2, false)
+// CompareOptions controls how commits missing from branch2 by hash are matched
+// against branch2 history
+type CompareOptions struct {
+	SinceMergeBase bool     // only consider branch2 commits after the merge-base with branch1
+	SinceRef       string   // only consider branch2 commits after this ref
+	Strategies     []string // matching strategies, in order (default: subject)
+	TrailerKeys    []string // trailers naming the original commit, for MatchTrailer
+	Ignore         []*regexp.Regexp
 }
 
-func FindMissingInteractive(branch1, branch2 string) {
-	FindMissingWithOptions(branch1, branch2, true)
+// FindMissingOptions controls how find-missing reports its result
+type FindMissingOptions struct {
+	CompareOptions
+	Interactive bool
+	Format      string // text or json
 }
 
-func FindMissingWithOptions(branch1, branch2 string, interactive bool) {
+// PortedCommit is a branch1 commit missing by hash that has an equivalent on branch2
+type PortedCommit struct {
+	Commit
+	Equivalent Equivalent
+}
+
+// Comparison is the result of comparing branch1 against branch2
+type Comparison struct {
+	Missing []Commit
+	Ported  []PortedCommit
+	// Commits lists the missing and ported commits together, in log order
+	Commits []Commit
+	// Ignored counts commits dropped by ignore rules
+	Ignored int
+	// Skipped lists the missing commits triaged as skip, which Missing leaves out
+	Skipped []Commit
+	// Triage holds the triage decisions on the missing and ported commits
+	Triage map[string]Triage
+	// PreMergeBase maps the hash of a missing commit to the equivalent branch2 commit
+	// found before the cut-off point (only set when history is limited)
+	PreMergeBase map[string]Equivalent
+	// Cutoff is the commit branch2 history was limited to, if any
+	Cutoff string
+}
+
+func init() {
+	var opts FindMissingOptions
+	var tui bool
+	var match, trailers, ignore stringList
+	RegisterCommand(&Command{
+		Name:       "find-missing",
+		Args:       "<branch1> [<branch2>]",
+		Summary:    "Find commits in branch1 missing from branch2",
+		GitCommand: true,
+		Description: `Commits are compared by hash first, then with the matching strategies so that
+cherry-picked commits are not reported as missing:
+
+  subject      same normalized subject (default)
+  patch-id     same patch (git patch-id --stable)
+  change-id    same Change-Id trailer
+  cherry-pick  a "(cherry picked from commit X)" line references the other commit
+  trailer      a trailer given with --trailer references the other commit
+
+<branch2> defaults to the defaultTarget configuration value. Options also read
+from configuration are described by 'git-tools help config'.`,
+		Flags: func(fs *FlagSet) {
+			fs.BoolVar(&opts.Interactive, "browse", "i", "Browse commits interactively with detailed view")
+			fs.Alias("interactive", "browse")
+			fs.BoolVar(&tui, "tui", "t", "Launch Terminal User Interface (dual-pane view)")
+			fs.BoolVar(&opts.SinceMergeBase, "since-merge-base", "", "Only match against branch2 commits after the merge-base")
+			fs.StringVar(&opts.SinceRef, "since-ref", "", "ref", "Only match against branch2 commits after <ref>")
+			fs.Var(&match, "match", "m", "strategies", "Comma-separated matching strategies, tried in order")
+			fs.Var(&trailers, "trailer", "", "key", "Trailer naming the original commit (repeatable)")
+			fs.Var(&ignore, "ignore", "", "regex", "Never report commits whose subject matches (repeatable)")
+			fs.StringVar(&opts.Format, "format", "", "format", "Output format: text or json")
+		},
+		Run: func(cmd *Command, args []string) int {
+			if len(args) < 1 || len(args) > 2 {
+				return cmd.UsageError("expected 1 or 2 branches, got %d", len(args))
+			}
+			if !IsGitRepo() {
+				fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
+				return ExitError
+			}
+			cfg, err := LoadConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return ExitError
+			}
+			if err := applyFindMissingConfig(cmd, cfg, &opts, match, trailers, ignore); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return ExitError
+			}
+			if len(args) == 1 {
+				target := cfg.Get("defaultTarget")
+				if target == "" {
+					return cmd.UsageError("no <branch2> given and defaultTarget is not configured")
+				}
+				args = append(args, target)
+			}
+			if opts.Format != "text" && opts.Format != "json" {
+				return cmd.UsageError("unknown format '%s' (expected text or json)", opts.Format)
+			}
+
+			if tui {
+				tuiOpts, err := loadTUIOptions(cfg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return ExitError
+				}
+				return FindMissingTUI(args[0], args[1], opts.CompareOptions, tuiOpts)
+			}
+			return FindMissingWithOptions(args[0], args[1], opts)
+		},
+	})
+}
+
+// applyFindMissingConfig fills options not given on the command line from configuration
+func applyFindMissingConfig(cmd *Command, cfg *Config, opts *FindMissingOptions, match, trailers, ignore []string) error {
+	if !cmd.Changed("match") {
+		match = cfg.List("match")
+	}
+	strategies, err := ParseMatchStrategies(splitList(match))
+	if err != nil {
+		return err
+	}
+	opts.Strategies = strategies
+
+	if !cmd.Changed("trailer") {
+		trailers = cfg.List("trailer")
+	}
+	opts.TrailerKeys = splitList(trailers)
+
+	if !cmd.Changed("ignore") {
+		ignore = cfg.All("ignore")
+	}
+	for _, pattern := range ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid ignore pattern '%s': %v", pattern, err)
+		}
+		opts.Ignore = append(opts.Ignore, re)
+	}
+
+	if !cmd.Changed("since-merge-base") && !cmd.Changed("since-ref") {
+		if opts.SinceMergeBase, err = cfg.Bool("sinceMergeBase"); err != nil {
+			return err
+		}
+	}
+	if !cmd.Changed("format") {
+		opts.Format = cfg.Get("format")
+	}
+	return nil
+}
+
+func FindMissing(branch1, branch2 string) int {
+	return FindMissingWithOptions(branch1, branch2, FindMissingOptions{Format: "text"})
+}
+
+func FindMissingInteractive(branch1, branch2 string) int {
+	return FindMissingWithOptions(branch1, branch2, FindMissingOptions{Interactive: true, Format: "text"})
+}
+
+// FindMissingWithOptions prints the commits of branch1 missing from branch2 and
+// returns the exit code
+func FindMissingWithOptions(branch1, branch2 string, opts FindMissingOptions) int {
 	// Check if we're in a Git repository
 	if !IsGitRepo() {
 		fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
-		os.Exit(1)
+		return ExitError
 	}
 
 	// Check if branches exist
 	if !BranchExists(branch1) {
 		fmt.Fprintf(os.Stderr, "Error: Branch '%s' does not exist\n", branch1)
-		os.Exit(1)
+		return ExitError
 	}
 
 	if !BranchExists(branch2) {
 		fmt.Fprintf(os.Stderr, "Error: Branch '%s' does not exist\n", branch2)
-		os.Exit(1)
+		return ExitError
+	}
+
+	if opts.Format != "json" {
+		fmt.Printf("Finding commits in '%s' that are missing from '%s'...\n\n", branch1, branch2)
+	}
+
+	comparison, err := compareBranches(branch1, branch2, opts.CompareOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	triage, err := OpenTriageStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
 	}
+	applyTriage(comparison, triage, branch2)
 
-	fmt.Printf("Finding commits in '%s' that are missing from '%s'...\n\n", branch1, branch2)
+	if opts.Format == "json" {
+		return displayCommitsJSON(comparison, branch1, branch2)
+	}
+
+	if len(comparison.Missing) == 0 {
+		fmt.Printf("No missing commits found. Branch '%s' is up to date with '%s'.\n", branch2, branch1)
+		printTriageSummary(comparison)
+		return ExitOK
+	}
+
+	if opts.Interactive {
+		displayCommitsInteractive(comparison, branch1, branch2)
+	} else {
+		displayCommitsNormal(comparison, branch1, branch2)
+	}
+	return ExitOK
+}
+
+// compareBranches finds the commits of branch1 that are missing from branch2, first by
+// hash and then with the matching strategies
+func compareBranches(branch1, branch2 string, opts CompareOptions) (*Comparison, error) {
+	if len(opts.Strategies) == 0 {
+		opts.Strategies = []string{MatchSubject}
+	}
 
 	// Get commits that are in branch1 but not in branch2 (by hash)
 	missingCommits, err := getMissingCommits(branch1, branch2)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting missing commits: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to get missing commits: %v", err)
 	}
 
-	// Get all commit subjects from branch2 for subject-based comparison (normalized)
-	branch2Subjects, err := getAllSubjects(branch2)
+	comparison := &Comparison{PreMergeBase: make(map[string]Equivalent)}
+	targetRevs := []string{branch2}
+	if opts.SinceRef != "" || opts.SinceMergeBase {
+		cutoff, err := historyCutoff(branch1, branch2, opts)
+		if err != nil {
+			return nil, err
+		}
+		if cutoff != "" {
+			comparison.Cutoff = cutoff
+			targetRevs = append(targetRevs, "^"+cutoff)
+		}
+	}
+
+	// Index branch1-only commits and the relevant branch2 history
+	ix := openCommitIndexOrMemory()
+	sourceHashes, err := ix.Update(branch1, "^"+branch2)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(opts.Strategies, MatchPatchID) {
+		if err := ix.EnsurePatchIDs(sourceHashes); err != nil {
+			return nil, err
+		}
+	}
+	targetHashes, err := ix.Update(targetRevs...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting subjects from branch2: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to read branch2 history: %v", err)
+	}
+	target, err := buildEquivalenceIndex(ix, targetHashes, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	// Filter missingCommits: only keep those whose normalized subject does NOT exist in branch2
-	filteredCommits := make([]Commit, 0, len(missingCommits))
-	for _, commit := range missingCommits {
-		normSubj := NormalizeSubject(commit.Subject)
-		if _, ok := branch2Subjects[normSubj]; !ok {
-			filteredCommits = append(filteredCommits, commit)
+	// History before the cut-off is only consulted to explain matches it would have
+	// produced; patch-ids are not computed for it since that is the slow part
+	var older *equivalenceIndex
+	if comparison.Cutoff != "" {
+		olderHashes, err := ix.Update(comparison.Cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read branch2 history: %v", err)
+		}
+		olderOpts := opts
+		olderOpts.Strategies = removeString(opts.Strategies, MatchPatchID)
+		if older, err = buildEquivalenceIndex(ix, olderHashes, olderOpts); err != nil {
+			return nil, err
 		}
 	}
 
-	if len(filteredCommits) == 0 {
-		fmt.Printf("No missing commits found. Branch '%s' is up to date with '%s'.\n", branch2, branch1)
-		return
+	comparison.Missing = make([]Commit, 0, len(missingCommits))
+	for _, commit := range missingCommits {
+		if matchesAny(opts.Ignore, commit.Subject) {
+			comparison.Ignored++
+			continue
+		}
+		comparison.Commits = append(comparison.Commits, commit)
+		info, ok := ix.Get(commit.Hash)
+		if !ok {
+			comparison.Missing = append(comparison.Missing, commit)
+			continue
+		}
+		if equivalent, ok := target.match(info); ok {
+			comparison.Ported = append(comparison.Ported, PortedCommit{Commit: commit, Equivalent: equivalent})
+			continue
+		}
+		if older != nil {
+			if equivalent, ok := older.match(info); ok {
+				comparison.PreMergeBase[commit.Hash] = equivalent
+			}
+		}
+		comparison.Missing = append(comparison.Missing, commit)
 	}
+	return comparison, nil
+}
 
-	if interactive {
-		displayCommitsInteractive(filteredCommits, branch1, branch2)
-	} else {
-		displayCommitsNormal(filteredCommits, branch1, branch2)
+// historyCutoff resolves the commit before which branch2 history is ignored. It is
+// empty when the branches share no history.
+func historyCutoff(branch1, branch2 string, opts CompareOptions) (string, error) {
+	if opts.SinceRef != "" {
+		output, err := exec.Command("git", "rev-parse", "--verify", "--quiet", opts.SinceRef+"^{commit}").Output()
+		if err != nil {
+			return "", fmt.Errorf("invalid --since-ref '%s'", opts.SinceRef)
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+	output, err := exec.Command("git", "merge-base", branch1, branch2).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil // unrelated histories
+		}
+		return "", fmt.Errorf("failed to find merge-base: %v", err)
 	}
+	return strings.TrimSpace(string(output)), nil
 }
 
-func displayCommitsNormal(filteredCommits []Commit, branch1, branch2 string) {
+func displayCommitsNormal(comparison *Comparison, branch1, branch2 string) {
+	filteredCommits := comparison.Missing
 	fmt.Printf("Found %d missing commit(s):\n\n", len(filteredCommits))
 
 	// Display missing commits as one-liners with color
@@ -83,7 +344,20 @@ func displayCommitsNormal(filteredCommits []Commit, branch1, branch2 string) {
 			ColorGreen, commit.Author, ColorReset,
 			ColorCyan, commit.Date, ColorReset,
 		)
+		if equivalent, ok := comparison.PreMergeBase[commit.Hash]; ok {
+			fmt.Printf("         %s\n", preMergeBaseNote(equivalent, comparison.Cutoff))
+		}
+		if triage, ok := comparison.Triage[commit.Hash]; ok {
+			fmt.Printf("         %s\n", triageNote(triage))
+		}
+	}
+	if n := len(comparison.PreMergeBase); n > 0 {
+		fmt.Printf("\n%d commit(s) have a match only in ignored '%s' history up to %s.\n", n, branch2, comparison.Cutoff[:8])
 	}
+	if comparison.Ignored > 0 {
+		fmt.Printf("\n%d commit(s) skipped by ignore rules.\n", comparison.Ignored)
+	}
+	printTriageSummary(comparison)
 
 	// Sort commits by date (oldest first)
 	sort.Slice(filteredCommits, func(i, j int) bool {
@@ -101,7 +375,8 @@ func displayCommitsNormal(filteredCommits []Commit, branch1, branch2 string) {
 	fmt.Printf("3. Or merge '%s' into '%s': git merge %s\n", branch1, branch2, branch1)
 }
 
-func displayCommitsInteractive(filteredCommits []Commit, branch1, branch2 string) {
+func displayCommitsInteractive(comparison *Comparison, branch1, branch2 string) {
+	filteredCommits := comparison.Missing
 	// Create detailed output for interactive viewing
 	var output strings.Builder
 	
@@ -120,7 +395,14 @@ func displayCommitsInteractive(filteredCommits []Commit, branch1, branch2 string
 		output.WriteString(fmt.Sprintf("Hash:    %s%s%s\n", ColorYellow, commit.Hash, ColorReset))
 		output.WriteString(fmt.Sprintf("Author:  %s%s%s\n", ColorGreen, commit.Author, ColorReset))
 		output.WriteString(fmt.Sprintf("Date:    %s%s%s\n", ColorCyan, commit.Date, ColorReset))
-		output.WriteString(fmt.Sprintf("Subject: %s\n\n", commit.Subject))
+		output.WriteString(fmt.Sprintf("Subject: %s\n", commit.Subject))
+		if equivalent, ok := comparison.PreMergeBase[commit.Hash]; ok {
+			output.WriteString(fmt.Sprintf("Note:    %s\n", preMergeBaseNote(equivalent, comparison.Cutoff)))
+		}
+		if triage, ok := comparison.Triage[commit.Hash]; ok {
+			output.WriteString(fmt.Sprintf("Triage:  %s\n", strings.TrimPrefix(triageNote(triage), "triaged ")))
+		}
+		output.WriteString("\n")
 		
 		if fullCommit != "" {
 			output.WriteString(fmt.Sprintf("Full message:\n%s\n", fullCommit))
@@ -146,6 +428,96 @@ func displayCommitsInteractive(filteredCommits []Commit, branch1, branch2 string
 	pipeToLess(output.String())
 }
 
+// preMergeBaseNote explains that a commit only has an equivalent in ignored history
+func preMergeBaseNote(equivalent Equivalent, cutoff string) string {
+	return fmt.Sprintf("%s matches %s in ignored history up to %s", equivalent.Strategy, equivalent.Hash[:8], cutoff[:8])
+}
+
+// triageNote describes a triage decision and its note
+func triageNote(triage Triage) string {
+	switch {
+	case triage.Status == "":
+		return "note: " + triage.Note
+	case triage.Note == "":
+		return "triaged " + triage.label()
+	}
+	return fmt.Sprintf("triaged %s: %s", triage.label(), triage.Note)
+}
+
+// printTriageSummary counts the commits that triage decisions took off the missing list
+func printTriageSummary(comparison *Comparison) {
+	if n := len(comparison.Skipped); n > 0 {
+		fmt.Printf("\n%d commit(s) skipped by triage (review them with --tui).\n", n)
+	}
+	backported := 0
+	for _, ported := range comparison.Ported {
+		if ported.Equivalent.Strategy == triageStrategy {
+			backported++
+		}
+	}
+	if backported > 0 {
+		fmt.Printf("\n%d commit(s) triaged as backported by hand.\n", backported)
+	}
+}
+
+// jsonCommit is the JSON representation of a commit in find-missing output
+type jsonCommit struct {
+	Hash       string  `json:"hash"`
+	Subject    string  `json:"subject"`
+	Author     string  `json:"author"`
+	Date       string  `json:"date"`
+	Equivalent string  `json:"equivalent,omitempty"`
+	Strategy   string  `json:"strategy,omitempty"`
+	Note       string  `json:"note,omitempty"`
+	Triage     *Triage `json:"triage,omitempty"`
+}
+
+func displayCommitsJSON(comparison *Comparison, branch1, branch2 string) int {
+	result := struct {
+		Branch1 string       `json:"branch1"`
+		Branch2 string       `json:"branch2"`
+		Cutoff  string       `json:"cutoff,omitempty"`
+		Missing []jsonCommit `json:"missing"`
+		Ported  []jsonCommit `json:"ported"`
+		Skipped []jsonCommit `json:"skipped"`
+		Ignored int          `json:"ignored"`
+	}{Branch1: branch1, Branch2: branch2, Cutoff: comparison.Cutoff, Ignored: comparison.Ignored,
+		Missing: []jsonCommit{}, Ported: []jsonCommit{}, Skipped: []jsonCommit{}}
+
+	triage := func(hash string) *Triage {
+		if triage, ok := comparison.Triage[hash]; ok {
+			return &triage
+		}
+		return nil
+	}
+
+	for _, commit := range comparison.Missing {
+		c := jsonCommit{Hash: commit.Hash, Subject: commit.Subject, Author: commit.Author, Date: commit.Date}
+		if equivalent, ok := comparison.PreMergeBase[commit.Hash]; ok {
+			c.Note = preMergeBaseNote(equivalent, comparison.Cutoff)
+		}
+		c.Triage = triage(commit.Hash)
+		result.Missing = append(result.Missing, c)
+	}
+	for _, ported := range comparison.Ported {
+		result.Ported = append(result.Ported, jsonCommit{Hash: ported.Hash, Subject: ported.Subject,
+			Author: ported.Author, Date: ported.Date, Equivalent: ported.Equivalent.Hash, Strategy: ported.Equivalent.Strategy,
+			Triage: triage(ported.Hash)})
+	}
+	for _, commit := range comparison.Skipped {
+		result.Skipped = append(result.Skipped, jsonCommit{Hash: commit.Hash, Subject: commit.Subject,
+			Author: commit.Author, Date: commit.Date, Triage: triage(commit.Hash)})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
 func getCommitDetails(hash string) (string, error) {
 	cmd := exec.Command("git", "show", "--no-patch", "--format=%B", hash)
 	output, err := cmd.Output()
@@ -210,22 +582,3 @@ func getMissingCommits(branch1, branch2 string) ([]Commit, error) {
 
 	return commits, nil
 }
-
-// getAllSubjects returns a set of all normalized commit subjects in a branch
-func getAllSubjects(branch string) (map[string]struct{}, error) {
-	cmd := exec.Command("git", "log", "--pretty=format:%s", branch)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get subjects: %v", err)
-	}
-	subjects := make(map[string]struct{})
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		normSubj := NormalizeSubject(scanner.Text())
-		subjects[normSubj] = struct{}{}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading subject output: %v", err)
-	}
-	return subjects, nil
-} 
\ No newline at end of file
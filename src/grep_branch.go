@@ -5,56 +5,881 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-func GrepBranch(searchAll bool, text string) {
+func init() {
+	var opts GrepOptions
+	var repoOpts RepoOptions
+	var searchAll, extended, fixed, tui bool
+	var patterns, not, trailers, releaseTags, releaseBranches stringList
+	RegisterCommand(&Command{
+		Name:       "grep-branch",
+		Args:       "[<pattern>]",
+		Summary:    "List branches containing commits whose message or diff matches",
+		GitCommand: true,
+		Description: `For every commit whose message matches <pattern>, all branches containing the
+commit are listed, whether the commit is the branch tip or buried in history.
+Patterns are basic regular expressions unless -E or -F is given; more patterns
+can be given with -e, and by default a commit matching any of them is reported.
+
+With --pickaxe or --pickaxe-regex, commits whose patches add or remove the given
+code are found as well, and the files where the change occurred are reported.
+By default a commit must satisfy every given query; with --or any one suffices.
+
+--trailer, --author and --committer restrict the commits found by the queries
+above, or select commits on their own. Trailers are read from the last paragraph
+of each message; the values of the requested trailers are shown with each commit.
+
+With --reflog and --lost, commits no branch contains any more are searched too:
+those still recorded in a reflog are reported with the most recent reflog entry
+containing them, the others as unreachable, each with a command restoring the
+commit to a new branch.
+
+With --first-release, the earliest release tag containing each commit, in version
+order, is shown ("first shipped in v2.3.1") together with the release branches
+containing it. Tags are compared as semantic versions; which tags and branches
+count as releases is set by the releaseTags and releaseBranches configuration.
+
+With --repos, --manifest or --discover, the search runs in several repositories
+at once and every result is prefixed with its repository.
+
+With --tui, the pattern is typed into a query bar and the search re-runs as you
+type. The selected branch can be checked out (c) or given a worktree (w).`,
+		Flags: func(fs *FlagSet) {
+			fs.BoolVar(&searchAll, "all", "a", "Search all refs (branches, remotes, tags)")
+			fs.BoolVar(&opts.Refs.Remotes, "remotes", "r", "Also search remote-tracking branches")
+			fs.BoolVar(&opts.Refs.Tags, "tags", "", "Also search tags")
+			fs.BoolVar(&opts.Refs.Reflog, "reflog", "", "Also search commits only reachable from reflogs")
+			fs.BoolVar(&opts.Refs.Lost, "lost", "", "Also search unreachable commits (e.g. from deleted branches)")
+			fs.Var(&patterns, "regexp", "e", "pattern", "Match commit messages against <pattern> (repeatable)")
+			fs.Var(&not, "not", "", "pattern", "Skip commits whose message matches <pattern> (repeatable)")
+			fs.BoolVar(&opts.IgnoreCase, "ignore-case", "i", "Match patterns without regard to case")
+			fs.BoolVar(&extended, "extended-regexp", "E", "Patterns are extended regular expressions")
+			fs.BoolVar(&fixed, "fixed-strings", "F", "Patterns are literal strings")
+			fs.BoolVar(&opts.AllMatch, "all-match", "", "Require a message to match every -e pattern")
+			fs.StringVar(&opts.Pickaxe, "pickaxe", "S", "string", "Find commits changing the number of occurrences of <string> (git log -S)")
+			fs.StringVar(&opts.PickaxeRegex, "pickaxe-regex", "G", "regex", "Find commits whose patch adds or removes lines matching <regex> (git log -G)")
+			fs.BoolVar(&opts.Or, "or", "", "Report commits matching any query instead of all of them")
+			fs.Var(&trailers, "trailer", "", "key[=value]", "Only commits with the trailer, its value containing <value> (repeatable)")
+			fs.StringVar(&opts.Author, "author", "", "pattern", "Only commits whose author matches <pattern>")
+			fs.StringVar(&opts.Committer, "committer", "", "pattern", "Only commits whose committer matches <pattern>")
+			fs.StringVar(&opts.Format, "format", "", "format", "Output format: text, json or csv")
+			fs.StringVar(&opts.GroupBy, "group-by", "", "what", "Group output by commit or by branch")
+			fs.BoolVar(&opts.Summary, "summary", "", "Only show the number of matching commits per branch")
+			fs.BoolVar(&opts.FirstRelease, "first-release", "", "Show the earliest release tag and the release branches containing each commit")
+			fs.Var(&releaseTags, "release-tags", "", "glob", "Tags marking releases (repeatable, default from releaseTags config)")
+			fs.Var(&releaseBranches, "release-branches", "", "glob", "Release branches (repeatable, default from releaseBranches config)")
+			fs.BoolVar(&opts.Release.Prereleases, "prereleases", "", "Count pre-release tags such as v2.0.0-rc.1 as releases")
+			fs.BoolVar(&tui, "tui", "t", "Search interactively in a Terminal User Interface")
+			repoOpts.AddFlags(fs)
+		},
+		Run: func(cmd *Command, args []string) int {
+			if len(args) > 1 {
+				return cmd.UsageError("expected 1 search pattern, got %d", len(args))
+			}
+			opts.Patterns = append(args, patterns...)
+			opts.Not = not
+			for _, spec := range trailers {
+				filter, err := ParseTrailerFilter(spec)
+				if err != nil {
+					return cmd.UsageError("%v", err)
+				}
+				opts.Trailers = append(opts.Trailers, filter)
+			}
+			if !tui && len(opts.Patterns) == 0 && opts.Pickaxe == "" && opts.PickaxeRegex == "" &&
+				len(opts.Trailers) == 0 && opts.Author == "" && opts.Committer == "" {
+				return cmd.UsageError("nothing to search for: give <pattern>, -e, --pickaxe, --pickaxe-regex, --trailer, --author or --committer")
+			}
+			if opts.Format == "" {
+				opts.Format = GrepFormatText
+			}
+			if opts.Format != GrepFormatText && opts.Format != GrepFormatJSON && opts.Format != GrepFormatCSV {
+				return cmd.UsageError("unknown format '%s' (expected text, json or csv)", opts.Format)
+			}
+			if opts.GroupBy != "" && opts.GroupBy != GroupByCommit && opts.GroupBy != GroupByBranch {
+				return cmd.UsageError("unknown grouping '%s' (expected commit or branch)", opts.GroupBy)
+			}
+			switch {
+			case extended && fixed:
+				return cmd.UsageError("-E and -F cannot be used together")
+			case extended:
+				opts.Syntax = PatternExtended
+			case fixed:
+				opts.Syntax = PatternFixed
+			default:
+				opts.Syntax = PatternBasic
+			}
+			opts.Refs.Branches = true
+			if searchAll {
+				opts.Refs.Remotes = true
+				opts.Refs.Tags = true
+			}
+			if opts.FirstRelease && !repoOpts.Enabled() {
+				if !IsGitRepo() {
+					fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
+					return ExitError
+				}
+				cfg, err := LoadConfig()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return ExitError
+				}
+				if !cmd.Changed("release-tags") {
+					releaseTags = cfg.List("releaseTags")
+				}
+				if !cmd.Changed("release-branches") {
+					releaseBranches = cfg.List("releaseBranches")
+				}
+				opts.Release.TagPatterns = splitList(releaseTags)
+				opts.Release.BranchPatterns = splitList(releaseBranches)
+			}
+			if repoOpts.Enabled() {
+				if tui {
+					return cmd.UsageError("--tui cannot be used with several repositories")
+				}
+				return RunInRepos(cmd, repoOpts, opts.Format)
+			}
+			if tui {
+				cfg, err := LoadConfig()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return ExitError
+				}
+				tuiOpts, err := loadTUIOptions(cfg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return ExitError
+				}
+				return GrepBranchTUI(opts, tuiOpts)
+			}
+			return GrepBranch(opts)
+		},
+	})
+}
+
+// RefSelection chooses which kinds of refs are searched and reported
+type RefSelection struct {
+	Branches bool
+	Remotes  bool
+	Tags     bool
+	Reflog   bool // commits reachable from reflog entries
+	Lost     bool // unreachable commits, as listed by git fsck
+}
+
+// GrepOptions controls what grep-branch searches for
+type GrepOptions struct {
+	Patterns     []string // commit message patterns (git log --grep)
+	Not          []string // patterns a commit message must not match
+	Syntax       PatternSyntax
+	IgnoreCase   bool
+	AllMatch     bool   // a message must match every pattern rather than any
+	Pickaxe      string // string added or removed by the patch (git log -S)
+	PickaxeRegex string // regex matching added or removed lines (git log -G)
+	Or           bool   // match any query rather than all of them
+	Trailers     []TrailerFilter
+	Author       string // author pattern (git log --author)
+	Committer    string // committer pattern (git log --committer)
+	Refs         RefSelection
+
+	FirstRelease bool // look up the first release tag and release branches
+	Release      ReleaseOptions
+
+	Format  string // text, json or csv
+	GroupBy string // commit, branch, or empty for one line per commit and ref
+	Summary bool   // only count matching commits per ref
+}
+
+// TrailerFilter selects commits carrying a trailer, optionally with a value
+type TrailerFilter struct {
+	Key   string
+	Value string // case-insensitive substring of the value; empty matches any value
+}
+
+// ParseTrailerFilter parses a KEY[=VALUE] trailer filter
+func ParseTrailerFilter(spec string) (TrailerFilter, error) {
+	key, value, _ := strings.Cut(spec, "=")
+	key = strings.TrimSuffix(strings.TrimSpace(key), ":")
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return TrailerFilter{}, fmt.Errorf("invalid trailer filter '%s' (expected KEY[=VALUE])", spec)
+	}
+	return TrailerFilter{Key: key, Value: strings.TrimSpace(value)}, nil
+}
+
+// matching returns the trailers satisfying the filter
+func (f TrailerFilter) matching(trailers []Trailer) []Trailer {
+	var found []Trailer
+	for _, t := range trailers {
+		if strings.EqualFold(t.Key, f.Key) && strings.Contains(strings.ToLower(t.Value), strings.ToLower(f.Value)) {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+// RefKind classifies refs
+type RefKind string
+
+const (
+	RefLocal  RefKind = "local"
+	RefRemote RefKind = "remote"
+	RefTag    RefKind = "tag"
+	RefReflog RefKind = "reflog" // a reflog entry such as HEAD@{3}
+)
+
+// refKindOrder is the order refs of each kind are listed in
+var refKindOrder = map[RefKind]int{RefLocal: 0, RefRemote: 1, RefTag: 2, RefReflog: 3, "": 4}
+
+// Ref is a branch, remote-tracking branch or tag and the commit it points at
+type Ref struct {
+	Name   string // short name, e.g. "main", "origin/main" or "v1.0"
+	Kind   RefKind
+	Commit string
+}
+
+// String returns the ref as shown in output, with tags marked like git log decorations
+func (r Ref) String() string {
+	switch r.Kind {
+	case RefTag:
+		return "tag: " + r.Name
+	case RefReflog:
+		return "reflog: " + r.Name
+	}
+	return r.Name
+}
+
+// GrepMatch is a commit matching the query together with the refs containing it
+type GrepMatch struct {
+	Commit
+	Refs     []Ref
+	Files    []string  // files whose changes matched a pickaxe query
+	Trailers []Trailer // trailers matching a --trailer filter
+
+	Unreachable bool   // found by --lost and in no searched reflog
+	Restore     string // command restoring a commit no selected ref contains
+
+	FirstRelease    string   // earliest release tag containing the commit, with --first-release
+	ReleaseBranches []string // release branches containing the commit, with --first-release
+
+	body string // message body, for --not and excerpts
+	time int64  // committer timestamp, for ordering
+}
+
+func GrepBranch(opts GrepOptions) int {
 	if !IsGitRepo() {
 		fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
-		os.Exit(1)
+		return ExitError
+	}
+
+	matches, err := grepCommits(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
 	}
 
-	var logArgs []string
-	if searchAll {
-		logArgs = []string{"log", "--all", "--grep", text, "--pretty=format:%H" + LogDelimiter + "%s" + LogDelimiter + "%D"}
-	} else {
-		logArgs = []string{"log", "--branches", "--grep", text, "--pretty=format:%H" + LogDelimiter + "%s" + LogDelimiter + "%D"}
+	switch opts.Format {
+	case GrepFormatJSON:
+		return displayGrepJSON(matches, opts)
+	case GrepFormatCSV:
+		return displayGrepCSV(matches, opts)
 	}
-	cmd := exec.Command("git", logArgs...)
-	output, err := cmd.Output()
+	displayGrepText(matches, opts)
+	return ExitOK
+}
+
+// grepCommits finds the commits matching opts and the selected refs containing each.
+// With --reflog or --lost, commits no ref contains get the reflog entries containing
+// them or are marked unreachable, and get a restore command.
+func grepCommits(opts GrepOptions) ([]GrepMatch, error) {
+	refs, err := listRefs(opts.Refs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error running git log: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+	if len(refs) == 0 && !opts.Refs.Reflog && !opts.Refs.Lost {
+		return nil, nil
 	}
 
-	seen := make(map[string]struct{}) // To avoid duplicate commit/branch pairs
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, LogDelimiter, 3)
-		if len(parts) < 3 {
+	matches, err := searchCommits(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(matches))
+	for i, match := range matches {
+		hashes[i] = match.Hash
+	}
+	containing, err := containingRefs(hashes, refs)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for i := range matches {
+		matches[i].Refs = containing[matches[i].Hash]
+		if len(matches[i].Refs) == 0 {
+			orphans = append(orphans, matches[i].Hash)
+		}
+	}
+	if len(orphans) > 0 {
+		if err := addOrphanRefs(matches, orphans, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.FirstRelease {
+		if err := addReleases(matches, opts.Release); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+// addOrphanRefs gives matches no selected ref contains the reflog entries containing
+// them (with --reflog) or marks them unreachable (with --lost), and a restore command
+func addOrphanRefs(matches []GrepMatch, orphans []string, opts GrepOptions) error {
+	var inReflog map[string][]Ref
+	if opts.Refs.Reflog {
+		entries, err := listReflogEntries()
+		if err != nil {
+			return err
+		}
+		if inReflog, err = containingRefs(orphans, entries); err != nil {
+			return err
+		}
+	}
+	for i := range matches {
+		match := &matches[i]
+		if len(match.Refs) > 0 {
+			continue
+		}
+		match.Refs = latestReflogEntries(inReflog[match.Hash])
+		match.Unreachable = len(match.Refs) == 0 && opts.Refs.Lost
+		match.Restore = fmt.Sprintf("git branch restored-%s %s", match.Hash[:8], match.Hash)
+	}
+	return nil
+}
+
+// refRevArgs returns the git rev-list options selecting the refs in sel
+func refRevArgs(sel RefSelection) []string {
+	var args []string
+	if sel.Branches {
+		args = append(args, "--branches")
+	}
+	if sel.Remotes {
+		args = append(args, "--remotes")
+	}
+	if sel.Tags {
+		args = append(args, "--tags")
+	}
+	if sel.Reflog {
+		args = append(args, "--reflog")
+	}
+	return args
+}
+
+// grepSource is a set of commits searched by the queries: the history of the
+// revisions in args, or exactly the commits in stdin when walking is disabled
+type grepSource struct {
+	args  []string
+	stdin string
+}
+
+// grepSources returns the commit sets to search for the selected refs
+func grepSources(sel RefSelection) ([]grepSource, error) {
+	var sources []grepSource
+	if args := refRevArgs(sel); len(args) > 0 {
+		sources = append(sources, grepSource{args: args})
+	}
+	if sel.Lost {
+		lost, err := unreachableCommits()
+		if err != nil {
+			return nil, err
+		}
+		if len(lost) > 0 {
+			sources = append(sources, grepSource{args: []string{"--no-walk", "--stdin"}, stdin: strings.Join(lost, "\n") + "\n"})
+		}
+	}
+	return sources, nil
+}
+
+// unreachableCommits returns the commits no ref points to, directly or through
+// history, ignoring reflogs (git fsck --unreachable --no-reflogs)
+func unreachableCommits() ([]string, error) {
+	output, err := exec.Command("git", "fsck", "--unreachable", "--no-reflogs", "--no-progress").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unreachable commits: %v", err)
+	}
+	var commits []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[1] == "commit" {
+			commits = append(commits, fields[2])
+		}
+	}
+	return commits, nil
+}
+
+// listReflogEntries returns every reflog entry as a ref named like HEAD@{3}
+func listReflogEntries() ([]Ref, error) {
+	output, err := exec.Command("git", "reflog", "--all", "--format=%gd"+LogDelimiter+"%H").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflogs: %v", err)
+	}
+	var entries []Ref
+	for _, line := range strings.Split(string(output), "\n") {
+		if name, hash, ok := strings.Cut(line, LogDelimiter); ok {
+			entries = append(entries, Ref{Name: name, Kind: RefReflog, Commit: hash})
+		}
+	}
+	return entries, nil
+}
+
+// latestReflogEntries keeps the most recent entry of each reflog, e.g. HEAD@{2} out
+// of HEAD@{2} and HEAD@{7}
+func latestReflogEntries(entries []Ref) []Ref {
+	var latest []Ref
+	index := make(map[string]int)
+	for _, entry := range entries {
+		name, n := splitReflogEntry(entry.Name)
+		i, seen := index[name]
+		if !seen {
+			index[name] = len(latest)
+			latest = append(latest, entry)
+			continue
+		}
+		if _, m := splitReflogEntry(latest[i].Name); n < m {
+			latest[i] = entry
+		}
+	}
+	return latest
+}
+
+// splitReflogEntry splits "HEAD@{3}" into "HEAD" and 3
+func splitReflogEntry(entry string) (string, int) {
+	at := strings.LastIndex(entry, "@{")
+	if at < 0 {
+		return entry, 0
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(entry[at+2:], "}"))
+	if err != nil {
+		return entry, 0
+	}
+	return entry[:at], n
+}
+
+// grepQueries returns the git log filter arguments of each query to run. Message
+// filters are folded into the pickaxe queries when all queries must match, since git
+// ANDs them; -S and -G cannot be combined in one git log and always run separately.
+func grepQueries(opts GrepOptions) [][]string {
+	var message []string
+	for _, pattern := range opts.Patterns {
+		message = append(message, "--grep", pattern)
+	}
+	if opts.AllMatch && message != nil {
+		message = append(message, "--all-match")
+	}
+	if message == nil {
+		// Trailers are matched on the parsed message, but letting git skip the
+		// commits without the keys saves reading the rest
+		message = trailerGreps(opts.Trailers, opts.Syntax)
+	}
+	var pickaxes [][]string
+	if opts.Pickaxe != "" {
+		pickaxes = append(pickaxes, []string{"-S", opts.Pickaxe})
+	}
+	if opts.PickaxeRegex != "" {
+		pickaxes = append(pickaxes, []string{"-G", opts.PickaxeRegex})
+	}
+
+	var queries [][]string
+	switch {
+	case len(pickaxes) == 0:
+		queries = [][]string{message}
+	case opts.Or && message != nil:
+		queries = append([][]string{message}, pickaxes...)
+	case opts.Or:
+		queries = pickaxes
+	default:
+		for i := range pickaxes {
+			pickaxes[i] = append(pickaxes[i], message...)
+		}
+		queries = pickaxes
+	}
+
+	// Case and syntax options and the author filters apply to every query,
+	// including -S and -G
+	var common []string
+	if opts.IgnoreCase {
+		common = append(common, "--regexp-ignore-case")
+	}
+	if opts.Author != "" {
+		common = append(common, "--author", opts.Author)
+	}
+	if opts.Committer != "" {
+		common = append(common, "--committer", opts.Committer)
+	}
+	common = append(common, opts.Syntax.gitArgs()...)
+	for i := range queries {
+		queries[i] = append(queries[i], common...)
+	}
+	return queries
+}
+
+// trailerGreps returns --grep options selecting the commits with a line starting
+// with each trailer key, or nil if the keys cannot be matched that way
+func trailerGreps(filters []TrailerFilter, syntax PatternSyntax) []string {
+	if len(filters) == 0 || syntax == PatternFixed {
+		return nil
+	}
+	var greps []string
+	for _, filter := range filters {
+		if strings.EqualFold(filter.Key, CherryPickedFromTrailer) {
+			return nil // also written as "(cherry picked from commit ...)"
+		}
+		// Bracket expressions read the same in basic and extended regexps
+		pattern := "^"
+		for _, r := range filter.Key {
+			switch {
+			case 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z':
+				pattern += "[" + strings.ToLower(string(r)) + strings.ToUpper(string(r)) + "]"
+			case '0' <= r && r <= '9' || r == '-':
+				pattern += string(r)
+			default:
+				return nil
+			}
+		}
+		greps = append(greps, "--grep", pattern+"[[:space:]]*:")
+	}
+	if len(filters) > 1 {
+		greps = append(greps, "--all-match")
+	}
+	return greps
+}
+
+// searchCommits runs the queries over the selected refs and combines their results,
+// newest commit first
+func searchCommits(opts GrepOptions) ([]GrepMatch, error) {
+	var exclude []*regexp.Regexp
+	for _, pattern := range opts.Not {
+		re, err := compilePattern(pattern, opts.Syntax, opts.IgnoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("--not: %v", err)
+		}
+		exclude = append(exclude, re)
+	}
+
+	sources, err := grepSources(opts.Refs)
+	if err != nil {
+		return nil, err
+	}
+	combined := make(map[string]*GrepMatch)
+	for _, source := range sources {
+		results, err := runGrepQueries(opts, source)
+		if err != nil {
+			return nil, err
+		}
+		for hash, match := range results {
+			if _, ok := combined[hash]; !ok {
+				combined[hash] = match
+			}
+		}
+	}
+
+	matches := make([]GrepMatch, 0, len(combined))
+	for _, match := range combined {
+		message := match.Subject + "\n\n" + match.body
+		if matchesAny(exclude, message) {
 			continue
 		}
-		hash := parts[0]
-		subject := parts[1]
-		refs := parts[2]
-		for _, ref := range strings.Split(refs, ",") {
-			ref = strings.TrimSpace(ref)
-			if ref == "" {
+		if len(opts.Trailers) > 0 {
+			if match.Trailers = matchTrailers(ParseTrailers(message), opts.Trailers); match.Trailers == nil {
 				continue
 			}
-			if strings.HasPrefix(ref, "tag: ") {
-				continue // skip tags
+		}
+		matches = append(matches, *match)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].time != matches[j].time {
+			return matches[i].time > matches[j].time
+		}
+		return matches[i].Hash < matches[j].Hash
+	})
+	return matches, nil
+}
+
+// runGrepQueries runs every query over one source and combines their results
+func runGrepQueries(opts GrepOptions, source grepSource) (map[string]*GrepMatch, error) {
+	var combined map[string]*GrepMatch
+	for i, query := range grepQueries(opts) {
+		results, err := runGrepQuery(query, source)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			combined = results
+			continue
+		}
+		for hash, match := range results {
+			existing, ok := combined[hash]
+			if ok {
+				existing.Files = mergeFiles(existing.Files, match.Files)
+			} else if opts.Or {
+				combined[hash] = match
 			}
-			if idx := strings.Index(ref, "->"); idx != -1 {
-				ref = strings.TrimSpace(ref[idx+2:])
+		}
+		if !opts.Or {
+			for hash := range combined {
+				if _, ok := results[hash]; !ok {
+					delete(combined, hash)
+				}
 			}
-			key := ref + ":" + hash
-			if _, ok := seen[key]; ok {
-				continue
+		}
+	}
+	return combined, nil
+}
+
+// matchTrailers returns the trailers satisfying the filters, or nil unless every
+// filter is satisfied
+func matchTrailers(trailers []Trailer, filters []TrailerFilter) []Trailer {
+	var found []Trailer
+	for _, filter := range filters {
+		matching := filter.matching(trailers)
+		if len(matching) == 0 {
+			return nil
+		}
+		for _, t := range matching {
+			if !containsTrailer(found, t) {
+				found = append(found, t)
 			}
-			seen[key] = struct{}{}
-			fmt.Printf("%s%s%s %s %s%s%s\n", ColorYellow, hash[:8], ColorReset, ref, ColorGreen, subject, ColorReset)
 		}
 	}
-} 
\ No newline at end of file
+	return found
+}
+
+func containsTrailer(trailers []Trailer, t Trailer) bool {
+	for _, existing := range trailers {
+		if existing == t {
+			return true
+		}
+	}
+	return false
+}
+
+// runGrepQuery runs one git log query and returns the matching commits by hash. For
+// pickaxe queries, git limits --name-only output to the files whose change matched.
+func runGrepQuery(filters []string, source grepSource) (map[string]*GrepMatch, error) {
+	pickaxe := false
+	for _, arg := range filters {
+		pickaxe = pickaxe || arg == "-S" || arg == "-G"
+	}
+
+	logArgs := []string{"log", "--date=short", "--pretty=format:" + RecordDelimiter +
+		strings.Join([]string{"%H", "%s", "%an", "%ad", "%ct", "%b", ""}, LogDelimiter)}
+	if pickaxe {
+		// Only pickaxe matches list files; the list costs a diff of every commit
+		logArgs = append(logArgs, "--name-only")
+	}
+	logArgs = append(logArgs, filters...)
+	logArgs = append(logArgs, source.args...)
+	cmd := exec.Command("git", logArgs...)
+	if source.stdin != "" {
+		cmd.Stdin = strings.NewReader(source.stdin)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git log: %v", err)
+	}
+
+	matches := make(map[string]*GrepMatch)
+	for _, record := range strings.Split(string(output), RecordDelimiter) {
+		parts := strings.Split(record, LogDelimiter)
+		if len(parts) < 7 {
+			continue
+		}
+		match := &GrepMatch{
+			Commit: Commit{Hash: parts[0], Subject: parts[1], Author: parts[2], Date: parts[3]},
+			body:   strings.TrimSpace(parts[5]),
+		}
+		fmt.Sscan(parts[4], &match.time)
+		if pickaxe {
+			for _, file := range strings.Split(parts[6], "\n") {
+				if file = strings.TrimSpace(file); file != "" {
+					match.Files = append(match.Files, file)
+				}
+			}
+		}
+		matches[match.Hash] = match
+	}
+	return matches, nil
+}
+
+// mergeFiles returns the union of two file lists, keeping the order of first appearance
+func mergeFiles(a, b []string) []string {
+	for _, file := range b {
+		if !containsString(a, file) {
+			a = append(a, file)
+		}
+	}
+	return a
+}
+
+// listRefs returns the selected refs, with tags peeled to the commit they point at
+func listRefs(sel RefSelection) ([]Ref, error) {
+	var patterns []string
+	if sel.Branches {
+		patterns = append(patterns, "refs/heads")
+	}
+	if sel.Remotes {
+		patterns = append(patterns, "refs/remotes")
+	}
+	if sel.Tags {
+		patterns = append(patterns, "refs/tags")
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	format := "--format=%(refname)" + LogDelimiter + "%(objectname)" + LogDelimiter + "%(objecttype)" +
+		LogDelimiter + "%(*objectname)" + LogDelimiter + "%(*objecttype)" + LogDelimiter + "%(symref)"
+	output, err := exec.Command("git", append([]string{"for-each-ref", format}, patterns...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %v", err)
+	}
+
+	var refs []Ref
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), LogDelimiter)
+		if len(parts) < 6 || parts[5] != "" {
+			continue // skip symbolic refs such as origin/HEAD
+		}
+		refname, commit, objectType := parts[0], parts[1], parts[2]
+		if objectType == "tag" {
+			commit, objectType = parts[3], parts[4]
+		}
+		if objectType != "commit" {
+			continue // tags of trees or blobs
+		}
+		ref := Ref{Commit: commit}
+		switch {
+		case strings.HasPrefix(refname, "refs/heads/"):
+			ref.Name, ref.Kind = strings.TrimPrefix(refname, "refs/heads/"), RefLocal
+		case strings.HasPrefix(refname, "refs/remotes/"):
+			ref.Name, ref.Kind = strings.TrimPrefix(refname, "refs/remotes/"), RefRemote
+		default:
+			ref.Name, ref.Kind = strings.TrimPrefix(refname, "refs/tags/"), RefTag
+		}
+		refs = append(refs, ref)
+	}
+	return refs, scanner.Err()
+}
+
+// refSet is a bitset over a list of refs
+type refSet []uint64
+
+// union returns a new set with the refs of both sets; sets are never modified in
+// place so they can be shared between commits
+func (s refSet) union(other refSet) refSet {
+	result := make(refSet, len(s))
+	for i := range s {
+		result[i] = s[i] | other[i]
+	}
+	return result
+}
+
+// containingRefs returns, for every target commit, the refs whose history contains it.
+// It walks the history of all refs once in topological order, children before parents,
+// pushing the set of refs reaching each commit down to its parents. Only the frontier
+// of the walk is kept in memory and the walk stops once every target has been seen.
+func containingRefs(targets []string, refs []Ref) (map[string][]Ref, error) {
+	result := make(map[string][]Ref, len(targets))
+	if len(targets) == 0 || len(refs) == 0 {
+		return result, nil
+	}
+
+	words := (len(refs) + 63) / 64
+	reach := make(map[string]refSet) // sets pending for commits not visited yet
+	for i, ref := range refs {
+		set, ok := reach[ref.Commit]
+		if !ok {
+			set = make(refSet, words)
+			reach[ref.Commit] = set
+		}
+		set[i/64] |= 1 << (i % 64)
+	}
+	pending := make(map[string]struct{}, len(targets))
+	for _, hash := range targets {
+		pending[hash] = struct{}{}
+	}
+
+	cmd := exec.Command("git", "rev-list", "--topo-order", "--parents", "--stdin")
+	tips := make([]string, 0, len(reach))
+	for hash := range reach {
+		tips = append(tips, hash)
+	}
+	cmd.Stdin = strings.NewReader(strings.Join(tips, "\n") + "\n")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to walk history: %v", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && len(pending) > 0 {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		hash := fields[0]
+		set := reach[hash]
+		delete(reach, hash)
+		if set == nil {
+			continue // not reachable from any selected ref (cannot happen for rev-list output)
+		}
+		if _, ok := pending[hash]; ok {
+			result[hash] = setRefs(set, refs)
+			delete(pending, hash)
+		}
+		for _, parent := range fields[1:] {
+			if existing, ok := reach[parent]; ok {
+				reach[parent] = existing.union(set)
+			} else {
+				reach[parent] = set
+			}
+		}
+	}
+
+	if len(pending) == 0 {
+		// Every target was found; the rest of history is not needed
+		cmd.Process.Kill()
+		cmd.Wait()
+		return result, nil
+	}
+	if err := scanner.Err(); err != nil {
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to walk history: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to walk history: %v", err)
+	}
+	return result, nil
+}
+
+// setRefs returns the refs in set, local branches first, then remotes and tags
+func setRefs(set refSet, refs []Ref) []Ref {
+	var result []Ref
+	for i, ref := range refs {
+		if set[i/64]&(1<<(i%64)) != 0 {
+			result = append(result, ref)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return refKindOrder[result[i].Kind] < refKindOrder[result[j].Kind]
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
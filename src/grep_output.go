@@ -0,0 +1,302 @@
+package gittools
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Output formats and groupings of grep-branch
+const (
+	GrepFormatText = "text"
+	GrepFormatJSON = "json"
+	GrepFormatCSV  = "csv"
+
+	GroupByCommit = "commit"
+	GroupByBranch = "branch"
+)
+
+// noBranch and unreachable stand in for the refs of a commit no selected ref contains
+var (
+	noBranch    = Ref{Name: "(no branch)"}
+	unreachable = Ref{Name: "(unreachable)"}
+)
+
+// displayRefs returns the refs listed for a match, with a placeholder if it has none
+func displayRefs(match GrepMatch) []Ref {
+	switch {
+	case len(match.Refs) > 0:
+		return match.Refs
+	case match.Unreachable:
+		return []Ref{unreachable}
+	}
+	return []Ref{noBranch}
+}
+
+// refGroup is a ref and the matching commits it contains
+type refGroup struct {
+	Ref     Ref
+	Commits []GrepMatch
+}
+
+// groupByRef regroups matches per containing ref, in the order of setRefs, with
+// commits no ref contains last
+func groupByRef(matches []GrepMatch) []refGroup {
+	var groups []refGroup
+	index := make(map[Ref]int)
+	for _, match := range matches {
+		for _, ref := range displayRefs(match) {
+			ref.Commit = "" // group by name, not by the tip the ref pointed at
+			i, ok := index[ref]
+			if !ok {
+				i = len(groups)
+				index[ref] = i
+				groups = append(groups, refGroup{Ref: ref})
+			}
+			groups[i].Commits = append(groups[i].Commits, match)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].Ref, groups[j].Ref
+		if a.Kind != b.Kind {
+			return refKindOrder[a.Kind] < refKindOrder[b.Kind]
+		}
+		return a.Name < b.Name
+	})
+	return groups
+}
+
+// summaryOrder sorts groups by number of commits, most first
+func summaryOrder(groups []refGroup) []refGroup {
+	sorted := append([]refGroup(nil), groups...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Commits) > len(sorted[j].Commits)
+	})
+	return sorted
+}
+
+// displayGrepText prints matches for people, with colors and highlighted matches
+func displayGrepText(matches []GrepMatch, opts GrepOptions) {
+	h := newHighlighter(opts.Patterns, opts.Syntax, opts.IgnoreCase)
+
+	if opts.Summary {
+		groups := summaryOrder(groupByRef(matches))
+		for _, group := range groups {
+			fmt.Printf("%6d  %s\n", len(group.Commits), group.Ref)
+		}
+		fmt.Printf("%d matching commit(s) on %d ref(s)\n", len(matches), len(groups))
+		return
+	}
+
+	switch opts.GroupBy {
+	case GroupByBranch:
+		for i, group := range groupByRef(matches) {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s%s%s (%d commit(s))\n", ColorCyan, group.Ref, ColorReset, len(group.Commits))
+			for _, match := range group.Commits {
+				fmt.Printf("  %s%s%s %s%s%s\n", ColorYellow, match.Hash[:8], ColorReset, ColorGreen, h.highlight(match.Subject, ColorGreen), ColorReset)
+			}
+		}
+	case GroupByCommit:
+		for _, match := range matches {
+			fmt.Printf("%s%s%s %s%s%s %s(%s, %s)%s\n", ColorYellow, match.Hash[:8], ColorReset,
+				ColorGreen, h.highlight(match.Subject, ColorGreen), ColorReset, ColorCyan, match.Author, match.Date, ColorReset)
+			var names []string
+			for _, ref := range displayRefs(match) {
+				names = append(names, ref.String())
+			}
+			fmt.Printf("         %son:%s %s\n", ColorCyan, ColorReset, strings.Join(names, ", "))
+			printMatchDetails(match, h, opts)
+		}
+	default:
+		for _, match := range matches {
+			subject := h.highlight(match.Subject, ColorGreen)
+			for _, ref := range displayRefs(match) {
+				fmt.Printf("%s%s%s %s %s%s%s\n", ColorYellow, match.Hash[:8], ColorReset, ref, ColorGreen, subject, ColorReset)
+			}
+			printMatchDetails(match, h, opts)
+		}
+	}
+}
+
+// printMatchDetails prints the body excerpt, trailers, files and releases below a match
+func printMatchDetails(match GrepMatch, h *highlighter, opts GrepOptions) {
+	if !h.matches(match.Subject) {
+		if line, ok := h.excerpt(match.body); ok {
+			fmt.Printf("         > %s\n", h.highlight(line, ""))
+		}
+	}
+	for _, t := range match.Trailers {
+		fmt.Printf("         %s%s:%s %s\n", ColorCyan, t.Key, ColorReset, t.Value)
+	}
+	if len(match.Files) > 0 {
+		fmt.Printf("         %sfiles:%s %s\n", ColorCyan, ColorReset, strings.Join(match.Files, ", "))
+	}
+	if match.Restore != "" {
+		fmt.Printf("         %srestore:%s %s\n", ColorCyan, ColorReset, match.Restore)
+	}
+	if opts.FirstRelease {
+		release := match.FirstRelease
+		if release == "" {
+			release = "(not released)"
+		}
+		fmt.Printf("         %sfirst release:%s %s\n", ColorCyan, ColorReset, release)
+		if len(match.ReleaseBranches) > 0 {
+			fmt.Printf("         %srelease branches:%s %s\n", ColorCyan, ColorReset, strings.Join(match.ReleaseBranches, ", "))
+		}
+	}
+}
+
+// jsonRef is the JSON representation of a ref in grep-branch output
+type jsonRef struct {
+	Name string  `json:"name"`
+	Kind RefKind `json:"kind"`
+}
+
+// jsonTrailer is the JSON representation of a trailer in grep-branch output
+type jsonTrailer struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// jsonGrepCommit is the JSON representation of a matching commit
+type jsonGrepCommit struct {
+	Hash     string        `json:"hash"`
+	Subject  string        `json:"subject"`
+	Author   string        `json:"author"`
+	Date     string        `json:"date"`
+	Refs     []jsonRef     `json:"refs,omitempty"`
+	Files    []string      `json:"files,omitempty"`
+	Trailers []jsonTrailer `json:"trailers,omitempty"`
+	// Unreachable and Restore are set for commits no selected ref contains
+	Unreachable bool   `json:"unreachable,omitempty"`
+	Restore     string `json:"restore,omitempty"`
+	// Set with --first-release
+	FirstRelease    string   `json:"firstRelease,omitempty"`
+	ReleaseBranches []string `json:"releaseBranches,omitempty"`
+}
+
+// jsonRefCount is the JSON representation of a ref in the summary
+type jsonRefCount struct {
+	jsonRef
+	Commits int `json:"commits"`
+}
+
+func newJSONGrepCommit(match GrepMatch, withRefs bool) jsonGrepCommit {
+	c := jsonGrepCommit{Hash: match.Hash, Subject: match.Subject, Author: match.Author, Date: match.Date, Files: match.Files,
+		Unreachable: match.Unreachable, Restore: match.Restore, FirstRelease: match.FirstRelease, ReleaseBranches: match.ReleaseBranches}
+	if withRefs {
+		c.Refs = []jsonRef{}
+		for _, ref := range match.Refs {
+			c.Refs = append(c.Refs, jsonRef{Name: ref.Name, Kind: ref.Kind})
+		}
+	}
+	for _, t := range match.Trailers {
+		c.Trailers = append(c.Trailers, jsonTrailer{Key: t.Key, Value: t.Value})
+	}
+	return c
+}
+
+// displayGrepJSON prints matches as a JSON object keyed by the grouping
+func displayGrepJSON(matches []GrepMatch, opts GrepOptions) int {
+	var result interface{}
+	switch {
+	case opts.Summary:
+		summary := struct {
+			Commits int            `json:"commits"`
+			Refs    []jsonRefCount `json:"refs"`
+		}{Commits: len(matches), Refs: []jsonRefCount{}}
+		for _, group := range summaryOrder(groupByRef(matches)) {
+			summary.Refs = append(summary.Refs, jsonRefCount{jsonRef{group.Ref.Name, group.Ref.Kind}, len(group.Commits)})
+		}
+		result = summary
+	case opts.GroupBy == GroupByBranch:
+		type jsonGroup struct {
+			jsonRef
+			Commits []jsonGrepCommit `json:"commits"`
+		}
+		groups := struct {
+			Refs []jsonGroup `json:"refs"`
+		}{Refs: []jsonGroup{}}
+		for _, group := range groupByRef(matches) {
+			g := jsonGroup{jsonRef: jsonRef{group.Ref.Name, group.Ref.Kind}}
+			for _, match := range group.Commits {
+				g.Commits = append(g.Commits, newJSONGrepCommit(match, false))
+			}
+			groups.Refs = append(groups.Refs, g)
+		}
+		result = groups
+	default:
+		commits := struct {
+			Commits []jsonGrepCommit `json:"commits"`
+		}{Commits: []jsonGrepCommit{}}
+		for _, match := range matches {
+			commits.Commits = append(commits.Commits, newJSONGrepCommit(match, true))
+		}
+		result = commits
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+// displayGrepCSV prints one row per commit and containing ref, ordered by the grouping
+func displayGrepCSV(matches []GrepMatch, opts GrepOptions) int {
+	w := csv.NewWriter(os.Stdout)
+	switch {
+	case opts.Summary:
+		w.Write([]string{"ref", "kind", "commits"})
+		for _, group := range summaryOrder(groupByRef(matches)) {
+			w.Write([]string{group.Ref.Name, string(group.Ref.Kind), fmt.Sprint(len(group.Commits))})
+		}
+	case opts.GroupBy == GroupByBranch:
+		w.Write(grepCSVHeader)
+		for _, group := range groupByRef(matches) {
+			for _, match := range group.Commits {
+				w.Write(grepCSVRow(match, group.Ref))
+			}
+		}
+	default:
+		w.Write(grepCSVHeader)
+		for _, match := range matches {
+			for _, ref := range displayRefs(match) {
+				w.Write(grepCSVRow(match, ref))
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+var grepCSVHeader = []string{"hash", "subject", "author", "date", "ref", "kind", "files", "trailers", "restore", "first_release", "release_branches"}
+
+func grepCSVRow(match GrepMatch, ref Ref) []string {
+	trailers := make([]string, len(match.Trailers))
+	for i, t := range match.Trailers {
+		trailers[i] = t.Key + ": " + t.Value
+	}
+	name, kind := ref.Name, string(ref.Kind)
+	switch ref {
+	case noBranch:
+		name = ""
+	case unreachable:
+		name, kind = "", "unreachable"
+	}
+	return []string{match.Hash, match.Subject, match.Author, match.Date, name, kind,
+		strings.Join(match.Files, ";"), strings.Join(trailers, ";"), match.Restore,
+		match.FirstRelease, strings.Join(match.ReleaseBranches, ";")}
+}
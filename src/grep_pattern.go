@@ -0,0 +1,198 @@
+package gittools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PatternSyntax is the regex flavor of grep-branch message patterns, as in git grep
+type PatternSyntax string
+
+const (
+	PatternBasic    PatternSyntax = "basic"    // POSIX basic regex, git's default
+	PatternExtended PatternSyntax = "extended" // POSIX extended regex (-E)
+	PatternFixed    PatternSyntax = "fixed"    // literal strings (-F)
+)
+
+// gitArgs returns the git log options selecting the syntax
+func (s PatternSyntax) gitArgs() []string {
+	switch s {
+	case PatternExtended:
+		return []string{"--extended-regexp"}
+	case PatternFixed:
+		return []string{"--fixed-strings"}
+	}
+	return nil
+}
+
+// compilePattern translates a git pattern into an equivalent Go regexp, for the
+// filtering and highlighting done outside git
+func compilePattern(pattern string, syntax PatternSyntax, ignoreCase bool) (*regexp.Regexp, error) {
+	var expr string
+	switch syntax {
+	case PatternFixed:
+		expr = regexp.QuoteMeta(pattern)
+	case PatternExtended:
+		expr = translateRegex(pattern, false)
+	default:
+		expr = translateRegex(pattern, true)
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+	}
+	return re, nil
+}
+
+// translateRegex rewrites a POSIX regex into RE2 syntax. In basic regexes the
+// characters ( ) { } | + ? are literal unless escaped (GNU extensions), the reverse of
+// RE2; the GNU word boundaries \< and \> become \b in both flavors.
+func translateRegex(pattern string, basic bool) string {
+	const special = "(){}|+?"
+	var b strings.Builder
+	classStart := -1 // index of the first character inside a bracket expression
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case classStart >= 0:
+			if c == '[' && strings.HasPrefix(pattern[i+1:], ":") {
+				if end := strings.Index(pattern[i:], ":]"); end > 0 {
+					b.WriteString(pattern[i : i+end+2]) // character class such as [:alpha:]
+					i += end + 1
+					continue
+				}
+			}
+			if c == '^' && i == classStart {
+				classStart++ // a ']' right after "[^" is literal too
+			} else if c == ']' && i > classStart {
+				classStart = -1
+			} else if c == '\\' || c == '[' {
+				b.WriteByte('\\') // literal inside POSIX brackets
+			}
+			b.WriteByte(c)
+		case c == '[':
+			classStart = i + 1
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			next := pattern[i]
+			switch {
+			case next == '<' || next == '>':
+				b.WriteString(`\b`)
+			case basic && strings.IndexByte(special, next) >= 0:
+				b.WriteByte(next)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(next)
+			}
+		case basic && strings.IndexByte(special, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '*' && (i == 0 || i == 1 && pattern[0] == '^'):
+			b.WriteString(`\*`) // a leading star is literal
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// highlighter marks the parts of commit messages matched by the message patterns
+type highlighter struct {
+	re *regexp.Regexp
+}
+
+// newHighlighter combines the patterns into one regexp; patterns Go cannot express
+// are left unhighlighted
+func newHighlighter(patterns []string, syntax PatternSyntax, ignoreCase bool) *highlighter {
+	var exprs []string
+	for _, pattern := range patterns {
+		if re, err := compilePattern(pattern, syntax, false); err == nil {
+			exprs = append(exprs, "(?:"+re.String()+")")
+		}
+	}
+	if len(exprs) == 0 {
+		return &highlighter{}
+	}
+	expr := strings.Join(exprs, "|")
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return &highlighter{re: regexp.MustCompile(expr)}
+}
+
+// matches reports whether s contains a match
+func (h *highlighter) matches(s string) bool {
+	return h.re != nil && h.re.MatchString(s)
+}
+
+// highlight wraps every match in s in the match color, returning to color afterwards
+func (h *highlighter) highlight(s, color string) string {
+	if h.re == nil {
+		return s
+	}
+	return h.re.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "" {
+			return m
+		}
+		return ColorBoldRed + m + ColorReset + color
+	})
+}
+
+// sgrSequence matches the color escape sequences in git's colored output
+var sgrSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// highlightANSI highlights matches in text already colored with escape sequences,
+// returning to the color in effect after each match. Matches spanning a color
+// change are not highlighted.
+func (h *highlighter) highlightANSI(text string) string {
+	if h.re == nil {
+		return text
+	}
+	var b strings.Builder
+	color := ""
+	for {
+		loc := sgrSequence.FindStringIndex(text)
+		if loc == nil {
+			b.WriteString(h.highlight(text, color))
+			return b.String()
+		}
+		b.WriteString(h.highlight(text[:loc[0]], color))
+		color = text[loc[0]:loc[1]]
+		b.WriteString(color)
+		text = text[loc[1]:]
+	}
+}
+
+// excerpt returns the first body line with a match, shortened around the match
+func (h *highlighter) excerpt(body string) (string, bool) {
+	if h.re == nil {
+		return "", false
+	}
+	const width = 100
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		loc := h.re.FindStringIndex(line)
+		if loc == nil || loc[0] == loc[1] {
+			continue
+		}
+		if len(line) > width {
+			start := max(0, loc[0]-width/3)
+			end := min(len(line), start+width)
+			prefix, suffix := "", ""
+			if start > 0 {
+				prefix = "..."
+			}
+			if end < len(line) {
+				suffix = "..."
+			}
+			line = prefix + strings.ToValidUTF8(line[start:end], "") + suffix
+		}
+		return line, true
+	}
+	return "", false
+}
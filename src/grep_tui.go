@@ -0,0 +1,486 @@
+package gittools
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jroimartin/gocui"
+)
+
+// grepSearchDelay is how long typing must pause before the query is re-run
+const grepSearchDelay = 300 * time.Millisecond
+
+// GrepTUI is the interactive grep-branch view: the query bar on top, matching commits,
+// the refs containing the selected commit and its patch side by side. It reuses the
+// scrolling of the find-missing TUI for the patch ("detail") view.
+type GrepTUI struct {
+	*TUI
+	opts       GrepOptions
+	matches    []GrepMatch
+	current    int // selected commit
+	currentRef int // selected ref of the selected commit
+	status     string
+
+	timer      *time.Timer
+	generation int // incremented per search; results of older searches are dropped
+	searching  bool
+}
+
+// GrepBranchTUI starts the interactive grep-branch view, searching for the given
+// pattern (if any) right away, and returns the exit code
+func GrepBranchTUI(opts GrepOptions, tuiOpts TUIOptions) int {
+	if !IsGitRepo() {
+		fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
+		return ExitError
+	}
+
+	g, err := gocui.NewGui(gocui.OutputNormal)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer g.Close()
+	g.Cursor = true
+	g.Mouse = true
+
+	diff := diffRenderer{theme: tuiOpts.Theme, wordDiff: tuiOpts.WordDiff, syntax: tuiOpts.Syntax}
+	t := &GrepTUI{TUI: &TUI{gui: g, diff: diff, theme: tuiOpts.Theme}, opts: opts}
+	g.SetManagerFunc(t.layout)
+	if err := t.setKeybindings(); err != nil {
+		log.Panicln(err)
+	}
+	t.search(t.query())
+
+	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
+		log.Panicln(err)
+	}
+	return ExitOK
+}
+
+// query returns the text of the query bar, or the pattern given on the command line
+// before the bar exists
+func (t *GrepTUI) query() string {
+	if v, err := t.gui.View("query"); err == nil {
+		return strings.TrimSpace(v.Buffer())
+	}
+	if len(t.opts.Patterns) > 0 {
+		return t.opts.Patterns[0]
+	}
+	return ""
+}
+
+func (t *GrepTUI) layout(g *gocui.Gui) error {
+	maxX, maxY := g.Size()
+	commitsWidth := maxX * 2 / 5
+	refsWidth := commitsWidth + maxX/5
+
+	if v, err := g.SetView("query", 0, 0, maxX-1, 2); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = "Search commit messages (Enter: run, Tab: next pane)"
+		v.Editable = true
+		v.Editor = gocui.EditorFunc(t.editQuery)
+		if len(t.opts.Patterns) > 0 {
+			fmt.Fprint(v, t.opts.Patterns[0])
+			v.SetCursor(len(t.opts.Patterns[0]), 0)
+		}
+		if _, err := g.SetCurrentView("query"); err != nil {
+			return err
+		}
+	}
+
+	if v, err := g.SetView("commits", 0, 3, commitsWidth, maxY-3); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = "Commits"
+		v.Highlight = true
+		v.SelBgColor = t.theme.selection.bg
+		v.SelFgColor = t.theme.selection.fg
+		t.renderCommits()
+	}
+
+	if v, err := g.SetView("refs", commitsWidth+1, 3, refsWidth, maxY-3); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = "Branches"
+		v.Highlight = true
+		v.SelBgColor = t.theme.selection.bg
+		v.SelFgColor = t.theme.selection.fg
+		t.renderRefs()
+	}
+
+	if v, err := g.SetView("detail", refsWidth+1, 3, maxX-1, maxY-3); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = "Commit Details (git show)"
+		t.renderPatch()
+	}
+
+	if v, err := g.SetView("status", -1, maxY-3, maxX, maxY); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Frame = false
+	}
+	t.renderStatus()
+	return nil
+}
+
+// editQuery edits the query bar and schedules a search once typing pauses
+func (t *GrepTUI) editQuery(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
+	before := v.Buffer()
+	gocui.DefaultEditor.Edit(v, key, ch, mod)
+	if v.Buffer() == before {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	query := strings.TrimSpace(v.Buffer())
+	t.timer = time.AfterFunc(grepSearchDelay, func() {
+		t.gui.Update(func(g *gocui.Gui) error {
+			t.search(query)
+			return nil
+		})
+	})
+}
+
+// search runs the query in the background; only the latest search updates the views
+func (t *GrepTUI) search(query string) {
+	t.generation++
+	generation := t.generation
+
+	opts := t.opts
+	opts.Patterns = nil
+	if query != "" {
+		opts.Patterns = []string{query}
+	}
+	if len(opts.Patterns) == 0 && opts.Pickaxe == "" && opts.PickaxeRegex == "" &&
+		len(opts.Trailers) == 0 && opts.Author == "" && opts.Committer == "" {
+		t.opts.Patterns = nil
+		t.setMatches(nil, "Type to search commit messages")
+		return
+	}
+
+	t.searching = true
+	t.renderStatus()
+	go func() {
+		matches, err := grepCommits(opts)
+		t.gui.Update(func(g *gocui.Gui) error {
+			if generation != t.generation {
+				return nil // superseded by a newer search
+			}
+			t.searching = false
+			if err != nil {
+				t.setMatches(nil, fmt.Sprintf("Error: %v", err))
+				return nil
+			}
+			t.opts.Patterns = opts.Patterns
+			t.setMatches(matches, fmt.Sprintf("%d matching commit(s)", len(matches)))
+			return nil
+		})
+	}()
+}
+
+// setMatches replaces the results and redraws every pane
+func (t *GrepTUI) setMatches(matches []GrepMatch, status string) {
+	t.searching = false
+	t.matches = matches
+	t.current = 0
+	t.currentRef = 0
+	t.status = status
+	t.renderCommits()
+	t.renderRefs()
+	t.renderPatch()
+	t.renderStatus()
+}
+
+func (t *GrepTUI) selected() (GrepMatch, bool) {
+	if t.current >= len(t.matches) {
+		return GrepMatch{}, false
+	}
+	return t.matches[t.current], true
+}
+
+func (t *GrepTUI) selectedRef() (Ref, bool) {
+	match, ok := t.selected()
+	if !ok || t.currentRef >= len(match.Refs) {
+		return Ref{}, false
+	}
+	return match.Refs[t.currentRef], true
+}
+
+func (t *GrepTUI) renderCommits() {
+	v, err := t.gui.View("commits")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	v.SetOrigin(0, 0)
+	h := newHighlighter(t.opts.Patterns, t.opts.Syntax, t.opts.IgnoreCase)
+	for _, match := range t.matches {
+		fmt.Fprintf(v, "%s %s (%d)\n", match.Hash[:8], h.highlight(match.Subject, ColorReset), len(match.Refs))
+	}
+	v.Title = fmt.Sprintf("Commits (%d)", len(t.matches))
+	t.setCursor(v, t.current)
+}
+
+func (t *GrepTUI) renderRefs() {
+	v, err := t.gui.View("refs")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	v.SetOrigin(0, 0)
+	match, ok := t.selected()
+	if !ok {
+		return
+	}
+	for _, ref := range displayRefs(match) {
+		fmt.Fprintln(v, ref)
+	}
+	t.setCursor(v, t.currentRef)
+}
+
+func (t *GrepTUI) renderPatch() {
+	v, err := t.gui.View("detail")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	v.SetOrigin(0, 0)
+	match, ok := t.selected()
+	if !ok {
+		return
+	}
+	patch, err := getCommitFullPatch(match.Hash)
+	if err != nil {
+		fmt.Fprintf(v, "Error getting commit details: %v", err)
+		return
+	}
+	fmt.Fprint(v, t.diff.render(patch))
+}
+
+func (t *GrepTUI) renderStatus() {
+	v, err := t.gui.View("status")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	status := t.status
+	if t.searching {
+		status = "Searching..."
+	}
+	fmt.Fprintf(v, "%s\n", status)
+	fmt.Fprint(v, "Tab:next pane  /:search  ↑↓/jk:navigate  c:checkout  w:worktree  PgUp/PgDn:patch  q:quit")
+}
+
+func (t *GrepTUI) setKeybindings() error {
+	type binding struct {
+		view    string
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}
+	bindings := []binding{
+		// Printable keys are bound per pane: global ones would fire while typing a query
+		{"", gocui.KeyCtrlC, quit},
+		{"", gocui.KeyPgup, t.pageUpPatch},
+		{"", gocui.KeyPgdn, t.pageDownPatch},
+		{"query", gocui.KeyEnter, t.runQuery},
+		{"query", gocui.KeyTab, t.nextPane},
+		{"query", gocui.KeyEsc, t.focus("commits")},
+		{"commits", gocui.KeyArrowUp, t.commitUp},
+		{"commits", gocui.KeyArrowDown, t.commitDown},
+		{"commits", 'k', t.commitUp},
+		{"commits", 'j', t.commitDown},
+		{"commits", 'h', t.scrollListLeft},
+		{"commits", 'l', t.scrollListRight},
+		{"commits", gocui.KeyEnter, t.focus("refs")},
+		{"refs", gocui.KeyArrowUp, t.refUp},
+		{"refs", gocui.KeyArrowDown, t.refDown},
+		{"refs", 'k', t.refUp},
+		{"refs", 'j', t.refDown},
+		{"refs", gocui.KeyEnter, t.focus("detail")},
+		{"refs", gocui.KeyEsc, t.focus("commits")},
+		{"detail", gocui.KeyArrowUp, t.scrollDetailUp},
+		{"detail", gocui.KeyArrowDown, t.scrollDetailDown},
+		{"detail", 'k', t.scrollDetailUp},
+		{"detail", 'j', t.scrollDetailDown},
+		{"detail", gocui.KeySpace, t.pageDownPatch},
+		{"detail", gocui.KeyEsc, t.focus("commits")},
+	}
+	for _, view := range []string{"commits", "refs", "detail"} {
+		bindings = append(bindings,
+			binding{view, 'q', quit},
+			binding{view, gocui.KeyTab, t.nextPane},
+			binding{view, '/', t.focus("query")},
+			binding{view, 'c', t.checkout},
+			binding{view, 'w', t.addWorktree},
+		)
+	}
+	for _, b := range bindings {
+		if err := t.gui.SetKeybinding(b.view, b.key, gocui.ModNone, b.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grepPanes is the Tab order of the panes
+var grepPanes = []string{"query", "commits", "refs", "detail"}
+
+func (t *GrepTUI) nextPane(g *gocui.Gui, v *gocui.View) error {
+	next := grepPanes[0]
+	for i, name := range grepPanes {
+		if v != nil && v.Name() == name {
+			next = grepPanes[(i+1)%len(grepPanes)]
+		}
+	}
+	_, err := g.SetCurrentView(next)
+	return err
+}
+
+func (t *GrepTUI) focus(name string) func(*gocui.Gui, *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		_, err := g.SetCurrentView(name)
+		return err
+	}
+}
+
+// runQuery searches immediately instead of waiting for the debounce delay
+func (t *GrepTUI) runQuery(g *gocui.Gui, v *gocui.View) error {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.search(strings.TrimSpace(v.Buffer()))
+	_, err := g.SetCurrentView("commits")
+	return err
+}
+
+func (t *GrepTUI) commitUp(g *gocui.Gui, v *gocui.View) error {
+	return t.selectCommit(t.current - 1)
+}
+
+func (t *GrepTUI) commitDown(g *gocui.Gui, v *gocui.View) error {
+	return t.selectCommit(t.current + 1)
+}
+
+func (t *GrepTUI) selectCommit(index int) error {
+	if index < 0 || index >= len(t.matches) {
+		return nil
+	}
+	t.current = index
+	t.currentRef = 0
+	if v, err := t.gui.View("commits"); err == nil {
+		t.setCursor(v, t.current)
+	}
+	t.renderRefs()
+	t.renderPatch()
+	return nil
+}
+
+func (t *GrepTUI) refUp(g *gocui.Gui, v *gocui.View) error {
+	if t.currentRef > 0 {
+		t.currentRef--
+		t.setCursor(v, t.currentRef)
+	}
+	return nil
+}
+
+func (t *GrepTUI) refDown(g *gocui.Gui, v *gocui.View) error {
+	if match, ok := t.selected(); ok && t.currentRef < len(match.Refs)-1 {
+		t.currentRef++
+		t.setCursor(v, t.currentRef)
+	}
+	return nil
+}
+
+// checkout switches the working tree to the selected ref: local branches directly,
+// remote-tracking branches through a new tracking branch, tags and reflog entries
+// detached
+func (t *GrepTUI) checkout(g *gocui.Gui, v *gocui.View) error {
+	ref, ok := t.selectedRef()
+	if !ok {
+		t.status = "No branch selected"
+		t.renderStatus()
+		return nil
+	}
+	var args []string
+	switch ref.Kind {
+	case RefLocal:
+		args = []string{"switch", ref.Name}
+	case RefRemote:
+		args = []string{"switch", "--track", ref.Name}
+	default:
+		args = []string{"switch", "--detach", ref.Name}
+	}
+	t.status = runGitStatus(args, fmt.Sprintf("Checked out %s", ref))
+	t.renderStatus()
+	return nil
+}
+
+// addWorktree creates a worktree for the selected ref next to the main worktree,
+// named <repository>-<branch>
+func (t *GrepTUI) addWorktree(g *gocui.Gui, v *gocui.View) error {
+	ref, ok := t.selectedRef()
+	if !ok {
+		t.status = "No branch selected"
+		t.renderStatus()
+		return nil
+	}
+	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		t.status = fmt.Sprintf("Error: failed to find worktree: %v", err)
+		t.renderStatus()
+		return nil
+	}
+	root := strings.TrimSpace(string(top))
+	name := ref.Name
+	if ref.Kind == RefReflog {
+		name = ref.Commit[:8]
+	}
+	path := filepath.Join(filepath.Dir(root), filepath.Base(root)+"-"+strings.ReplaceAll(name, "/", "-"))
+
+	var args []string
+	switch ref.Kind {
+	case RefLocal:
+		args = []string{"worktree", "add", path, ref.Name}
+	case RefRemote:
+		local := ref.Name[strings.Index(ref.Name, "/")+1:]
+		if BranchExists(local) {
+			args = []string{"worktree", "add", "--detach", path, ref.Name}
+		} else {
+			args = []string{"worktree", "add", "--track", "-b", local, path, ref.Name}
+		}
+	default:
+		args = []string{"worktree", "add", "--detach", path, ref.Name}
+	}
+	t.status = runGitStatus(args, fmt.Sprintf("Created worktree %s for %s", path, ref))
+	t.renderStatus()
+	return nil
+}
+
+// runGitStatus runs a git command for its side effect and returns the status line
+// describing the outcome
+func runGitStatus(args []string, success string) string {
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		message := strings.TrimSpace(string(output))
+		if i := strings.IndexByte(message, '\n'); i >= 0 {
+			message = message[:i]
+		}
+		if message == "" {
+			message = err.Error()
+		}
+		return fmt.Sprintf("Error: git %s: %s", args[0], message)
+	}
+	return success
+}
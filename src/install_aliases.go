@@ -0,0 +1,178 @@
+package gittools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	var opts AliasOptions
+	RegisterCommand(&Command{
+		Name:    "install-aliases",
+		Summary: "Make commands available as git subcommands (git find-missing, ...)",
+		Description: `By default a git-<command> symlink to this binary is created for every command
+that can run as a git subcommand. git finds them as long as the directory is in
+PATH. With --git-alias, git aliases are configured instead.`,
+		Flags: func(fs *FlagSet) {
+			fs.StringVar(&opts.Dir, "dir", "", "dir", "Directory for the symlinks (default: the directory of this binary)")
+			fs.BoolVar(&opts.GitAlias, "git-alias", "", "Configure git aliases instead of creating symlinks")
+			fs.BoolVar(&opts.Local, "local", "", "With --git-alias, configure the current repository instead of the global config")
+			fs.BoolVar(&opts.Force, "force", "f", "Replace existing symlinks or aliases")
+			fs.BoolVar(&opts.Uninstall, "uninstall", "", "Remove symlinks or aliases installed by this command")
+		},
+		Run: func(cmd *Command, args []string) int {
+			if len(args) != 0 {
+				return cmd.UsageError("unexpected argument: %s", args[0])
+			}
+			if opts.Local && !opts.GitAlias {
+				return cmd.UsageError("--local requires --git-alias")
+			}
+			if err := InstallAliases(opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return ExitError
+			}
+			return ExitOK
+		},
+	})
+}
+
+// AliasOptions controls how install-aliases exposes commands to git
+type AliasOptions struct {
+	Dir       string
+	GitAlias  bool
+	Local     bool
+	Force     bool
+	Uninstall bool
+}
+
+// gitCommands returns the names of the commands that can run as git subcommands
+func gitCommands() []string {
+	var names []string
+	for _, cmd := range sortedCommands() {
+		if cmd.GitCommand {
+			names = append(names, cmd.Name)
+		}
+	}
+	return names
+}
+
+// InstallAliases creates (or removes) a git-<name> symlink or git alias per git command
+func InstallAliases(opts AliasOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate git-tools binary: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to locate git-tools binary: %v", err)
+	}
+
+	if opts.GitAlias {
+		return installGitAliases(exe, opts)
+	}
+	return installSymlinks(exe, opts)
+}
+
+func installSymlinks(exe string, opts AliasOptions) error {
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Dir(exe)
+	}
+
+	for _, name := range gitCommands() {
+		link := filepath.Join(dir, "git-"+name)
+		target, readErr := os.Readlink(link)
+		ours := readErr == nil && target == exe
+
+		if opts.Uninstall {
+			if !ours {
+				continue
+			}
+			if err := os.Remove(link); err != nil {
+				return fmt.Errorf("failed to remove %s: %v", link, err)
+			}
+			fmt.Printf("Removed %s\n", link)
+			continue
+		}
+
+		if ours {
+			fmt.Printf("%s already installed\n", link)
+			continue
+		}
+		if _, err := os.Lstat(link); err == nil {
+			if !opts.Force {
+				return fmt.Errorf("%s already exists (use --force to replace it)", link)
+			}
+			if err := os.Remove(link); err != nil {
+				return fmt.Errorf("failed to remove %s: %v", link, err)
+			}
+		}
+		if err := os.Symlink(exe, link); err != nil {
+			return fmt.Errorf("failed to create %s: %v", link, err)
+		}
+		fmt.Printf("Created %s -> %s\n", link, exe)
+	}
+
+	if !opts.Uninstall && !dirInPath(dir) {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not in PATH; git will not find the commands\n", dir)
+	}
+	return nil
+}
+
+func installGitAliases(exe string, opts AliasOptions) error {
+	scope := "--global"
+	if opts.Local {
+		scope = "--local"
+	}
+
+	for _, name := range gitCommands() {
+		key := "alias." + name
+		value := fmt.Sprintf("!%s %s", shellQuote(exe), name)
+		existing, _ := exec.Command("git", "config", scope, "--get", key).Output()
+		current := strings.TrimSpace(string(existing))
+
+		if opts.Uninstall {
+			if current != value {
+				continue
+			}
+			if err := exec.Command("git", "config", scope, "--unset", key).Run(); err != nil {
+				return fmt.Errorf("failed to remove alias %s: %v", name, err)
+			}
+			fmt.Printf("Removed alias %s\n", name)
+			continue
+		}
+
+		if current == value {
+			fmt.Printf("Alias %s already installed\n", name)
+			continue
+		}
+		if current != "" && !opts.Force {
+			return fmt.Errorf("alias %s already set to '%s' (use --force to replace it)", name, current)
+		}
+		if err := exec.Command("git", "config", scope, key, value).Run(); err != nil {
+			return fmt.Errorf("failed to set alias %s: %v", name, err)
+		}
+		fmt.Printf("Set alias %s = %s\n", name, value)
+	}
+	return nil
+}
+
+// dirInPath reports whether dir is one of the directories in PATH
+func dirInPath(dir string) bool {
+	for _, entry := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(entry) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes a path for the shell git uses to run "!" aliases
+func shellQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\n'\"\\$`") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -2,81 +2,96 @@ package gittools
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// cliModeEnv makes the binary run as git-tools whatever it is invoked as; commands
+// run again in other repositories (see RunInRepos) always get the command name first
+const cliModeEnv = "GIT_TOOLS_CLI"
+
 func RunCLI() {
-	if len(os.Args) < 2 {
-		PrintUsage()
-		os.Exit(1)
+	if name, ok := gitSubcommandName(os.Args[0]); ok && os.Getenv(cliModeEnv) == "" {
+		os.Exit(runGitSubcommand(name, os.Args[1:]))
+	}
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// gitSubcommandName returns the command name when the binary is invoked as
+// git-<name> for a git command, e.g. through a symlink created by install-aliases.
+// Other names, such as git-tools-linux-amd64 from make build-all, run the CLI.
+func gitSubcommandName(argv0 string) (string, bool) {
+	base := strings.TrimSuffix(filepath.Base(argv0), ".exe")
+	name, ok := strings.CutPrefix(base, "git-")
+	if !ok {
+		return "", false
+	}
+	cmd, ok := lookupCommand(name)
+	if !ok || !cmd.GitCommand {
+		return "", false
+	}
+	return name, true
+}
+
+func runGitSubcommand(name string, args []string) int {
+	gitMode = true
+	cmd, _ := lookupCommand(name)
+	return cmd.Execute(args)
+}
+
+func runCLI(args []string) int {
+	if len(args) < 1 {
+		PrintUsage(os.Stderr)
+		return ExitUsage
 	}
 
-	subcmd := os.Args[1]
+	subcmd := args[0]
 
 	switch subcmd {
-	case "find-missing":
-		handleFindMissing()
-	case "grep-branch":
-		handleGrepBranch()
-	default:
+	case "-h", "--help":
+		PrintUsage(os.Stdout)
+		return ExitOK
+	case "--version", "version":
+		fmt.Printf("%s version %s\n", programName(), Version)
+		return ExitOK
+	case "help":
+		return runHelp(args[1:])
+	}
+
+	cmd, ok := lookupCommand(subcmd)
+	if !ok {
 		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", subcmd)
-		PrintUsage()
-		os.Exit(1)
+		PrintUsage(os.Stderr)
+		return ExitUsage
 	}
+	return cmd.Execute(args[1:])
 }
 
-func handleFindMissing() {
-	args := os.Args[2:]
-	interactive := false
-	tui := false
-	
-	// Check for interactive flags
-	var branches []string
-	for _, arg := range args {
-		if arg == "--browse" || arg == "-i" || arg == "--interactive" {
-			interactive = true
-		} else if arg == "--tui" || arg == "-t" {
-			tui = true
-		} else {
-			branches = append(branches, arg)
-		}
-	}
-	
-	if len(branches) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s find-missing [--browse|-i] [--tui|-t] <branch1> <branch2>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  --browse, -i, --interactive: Browse commits interactively with detailed view\n")
-		fmt.Fprintf(os.Stderr, "  --tui, -t: Launch Terminal User Interface (dual-pane view)\n")
-		os.Exit(1)
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		PrintUsage(os.Stdout)
+		return ExitOK
 	}
-	
-	if tui {
-		FindMissingTUI(branches[0], branches[1])
-	} else if interactive {
-		FindMissingInteractive(branches[0], branches[1])
-	} else {
-		FindMissing(branches[0], branches[1])
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args[0])
+		PrintUsage(os.Stderr)
+		return ExitUsage
 	}
+	cmd.PrintHelp(os.Stdout)
+	return ExitOK
 }
 
-func handleGrepBranch() {
-	args := os.Args[2:]
-	if len(args) == 1 {
-		GrepBranch(false, args[0])
-	} else if len(args) == 2 && args[0] == "--all" {
-		GrepBranch(true, args[1])
-	} else {
-		fmt.Fprintf(os.Stderr, "Usage: %s grep-branch [--all] \"text\"\n", os.Args[0])
-		os.Exit(1)
+func PrintUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [<options>] [<args>]\n\n", programName())
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range sortedCommands() {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.Name, cmd.Summary)
 	}
+	fmt.Fprintln(w, "\nGlobal options:")
+	fmt.Fprintln(w, "  -h, --help     Show this help message")
+	fmt.Fprintln(w, "      --version  Show the version")
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the options of a command.\n", programName())
 }
-
-func PrintUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  git-tools find-missing [--browse|-i] [--tui|-t] <branch1> <branch2>")
-	fmt.Println("                         # Find commits in branch1 missing from branch2")
-	fmt.Println("                         # --browse/-i: Interactive detailed view")
-	fmt.Println("                         # --tui/-t: Terminal User Interface (dual-pane)")
-	fmt.Println("  git-tools grep-branch [--all] \"text\"")
-	fmt.Println("                         # List branches and commits where text exists in commit message")
-	fmt.Println("                         # --all: search all refs (branches, remotes, tags)")
-} 
\ No newline at end of file
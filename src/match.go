@@ -0,0 +1,173 @@
+package gittools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strategies used to recognize a branch1 commit that was ported to branch2 under
+// a different hash
+const (
+	MatchSubject    = "subject"     // same normalized subject
+	MatchPatchID    = "patch-id"    // same stable patch-id (git patch-id --stable)
+	MatchChangeID   = "change-id"   // same Change-Id trailer
+	MatchCherryPick = "cherry-pick" // "(cherry picked from commit X)" referencing the other commit
+	MatchTrailer    = "trailer"     // a configured trailer whose value is the other commit's hash
+)
+
+var matchStrategies = []string{MatchSubject, MatchPatchID, MatchChangeID, MatchCherryPick, MatchTrailer}
+
+// ParseMatchStrategies validates a list of strategy names
+func ParseMatchStrategies(names []string) ([]string, error) {
+	var strategies []string
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !containsString(matchStrategies, name) {
+			return nil, fmt.Errorf("unknown match strategy '%s' (expected one of %s)", name, strings.Join(matchStrategies, ", "))
+		}
+		strategies = append(strategies, name)
+	}
+	return strategies, nil
+}
+
+// Equivalent is a branch2 commit found to correspond to a branch1 commit
+type Equivalent struct {
+	Hash     string
+	Strategy string
+}
+
+// equivalenceIndex maps the matching keys of a set of commits to the commit carrying them
+type equivalenceIndex struct {
+	strategies  []string
+	trailerKeys []string
+	keys        map[string]string   // strategy + "\x00" + key -> hash
+	hashes      map[string][]string // every indexed commit, bucketed by its first 7 characters
+	// references from indexed commits to other commits by (possibly abbreviated) hash,
+	// bucketed by their first 7 characters
+	refs map[string][]reference
+}
+
+type reference struct {
+	target, hash, strategy string
+}
+
+// buildEquivalenceIndex indexes the given commits for the strategies in opts
+func buildEquivalenceIndex(ix *CommitIndex, hashes []string, opts CompareOptions) (*equivalenceIndex, error) {
+	e := &equivalenceIndex{
+		strategies:  opts.Strategies,
+		trailerKeys: opts.TrailerKeys,
+		keys:        make(map[string]string),
+		hashes:      make(map[string][]string, len(hashes)),
+		refs:        make(map[string][]reference),
+	}
+	if e.uses(MatchPatchID) {
+		if err := ix.EnsurePatchIDs(hashes); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, hash := range hashes {
+		info, ok := ix.Get(hash)
+		if !ok {
+			continue
+		}
+		e.hashes[hash[:7]] = append(e.hashes[hash[:7]], hash)
+		for _, strategy := range e.strategies {
+			switch strategy {
+			case MatchCherryPick, MatchTrailer:
+				for _, target := range e.references(info, strategy) {
+					bucket := target[:min(7, len(target))]
+					e.refs[bucket] = append(e.refs[bucket], reference{target: target, hash: hash, strategy: strategy})
+				}
+			default:
+				if key := matchKey(info, strategy); key != "" {
+					if _, seen := e.keys[strategy+"\x00"+key]; !seen {
+						e.keys[strategy+"\x00"+key] = hash
+					}
+				}
+			}
+		}
+	}
+	return e, nil
+}
+
+func (e *equivalenceIndex) uses(strategy string) bool {
+	return containsString(e.strategies, strategy)
+}
+
+// matchKey returns the value a commit is matched on for key-based strategies
+func matchKey(info *CommitInfo, strategy string) string {
+	switch strategy {
+	case MatchSubject:
+		return info.Subject
+	case MatchPatchID:
+		if info.PatchID != nil {
+			return *info.PatchID
+		}
+	case MatchChangeID:
+		return info.ChangeID
+	}
+	return ""
+}
+
+// references returns the commit hashes a commit points at for reference strategies
+func (e *equivalenceIndex) references(info *CommitInfo, strategy string) []string {
+	var targets []string
+	for _, t := range info.Trailers {
+		isRef := false
+		if strategy == MatchCherryPick {
+			isRef = t.Key == CherryPickedFromTrailer
+		} else {
+			for _, key := range e.trailerKeys {
+				isRef = isRef || strings.EqualFold(t.Key, key)
+			}
+		}
+		fields := strings.Fields(t.Value)
+		if !isRef || len(fields) == 0 {
+			continue
+		}
+		if value := strings.ToLower(fields[0]); len(value) >= 7 && isHex(value) {
+			targets = append(targets, value)
+		}
+	}
+	return targets
+}
+
+// match returns the indexed commit equivalent to info, trying strategies in order
+func (e *equivalenceIndex) match(info *CommitInfo) (Equivalent, bool) {
+	for _, strategy := range e.strategies {
+		switch strategy {
+		case MatchCherryPick, MatchTrailer:
+			// An indexed commit pointing at this one...
+			for _, ref := range e.refs[info.Hash[:7]] {
+				if ref.strategy == strategy && strings.HasPrefix(info.Hash, ref.target) {
+					return Equivalent{Hash: ref.hash, Strategy: strategy}, true
+				}
+			}
+			// ...or this commit pointing at an indexed one
+			for _, target := range e.references(info, strategy) {
+				for _, hash := range e.hashes[target[:7]] {
+					if strings.HasPrefix(hash, target) {
+						return Equivalent{Hash: hash, Strategy: strategy}, true
+					}
+				}
+			}
+		default:
+			if key := matchKey(info, strategy); key != "" {
+				if hash, ok := e.keys[strategy+"\x00"+key]; ok {
+					return Equivalent{Hash: hash, Strategy: strategy}, true
+				}
+			}
+		}
+	}
+	return Equivalent{}, false
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,315 @@
+package gittools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// RepoOptions selects the repositories a command runs in. Commands supporting
+// several repositories register the options with AddFlags and hand over to
+// RunInRepos when Enabled.
+type RepoOptions struct {
+	Repos    stringList // repository paths, comma-separated or repeated
+	Manifest string     // file listing one repository path per line
+	Discover string     // directory searched for repositories
+	Jobs     int        // repositories processed concurrently
+}
+
+// repoFlags are the long names of the options added by AddFlags
+var repoFlags = []string{"repos", "manifest", "discover", "jobs"}
+
+// discoverDepth limits how deep --discover looks for repositories
+const discoverDepth = 4
+
+// AddFlags registers the multi-repository options
+func (o *RepoOptions) AddFlags(fs *FlagSet) {
+	fs.Var(&o.Repos, "repos", "", "paths", "Run in each of these repositories (comma-separated, repeatable)")
+	fs.StringVar(&o.Manifest, "manifest", "", "file", "Run in each repository listed in <file>, one path per line")
+	fs.StringVar(&o.Discover, "discover", "", "dir", "Run in each repository found under <dir>")
+	fs.IntVar(&o.Jobs, "jobs", "j", "n", "Number of repositories searched concurrently (default: number of CPUs)")
+}
+
+// Enabled reports whether any repositories were selected
+func (o *RepoOptions) Enabled() bool {
+	return len(o.Repos) > 0 || o.Manifest != "" || o.Discover != ""
+}
+
+// Repo is a repository a command runs in
+type Repo struct {
+	Name string // shown in front of results: the path as given, or relative to the manifest or discovery directory
+	Path string
+}
+
+// ResolveRepos returns the selected repositories in a stable order, without duplicates
+func (o *RepoOptions) ResolveRepos() ([]Repo, error) {
+	var repos []Repo
+	for _, path := range splitList(o.Repos) {
+		repos = append(repos, Repo{Name: filepath.Clean(path), Path: path})
+	}
+	if o.Manifest != "" {
+		listed, err := readManifest(o.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, listed...)
+	}
+	if o.Discover != "" {
+		found, err := discoverRepos(o.Discover)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, found...)
+	}
+
+	var unique []Repo
+	seen := make(map[string]bool)
+	for _, repo := range repos {
+		abs, err := filepath.Abs(repo.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", repo.Path, err)
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			repo.Path = abs
+			unique = append(unique, repo)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, fmt.Errorf("no repositories found")
+	}
+	return unique, nil
+}
+
+// readManifest reads repository paths, one per line, relative to the manifest's
+// directory. Blank lines and lines starting with # are ignored.
+func readManifest(path string) ([]Repo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	defer file.Close()
+
+	var repos []Repo
+	dir := filepath.Dir(path)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repoPath := line
+		if !filepath.IsAbs(repoPath) {
+			repoPath = filepath.Join(dir, repoPath)
+		}
+		repos = append(repos, Repo{Name: filepath.Clean(line), Path: repoPath})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	return repos, nil
+}
+
+// discoverRepos finds the repositories (directories containing .git) under root,
+// without descending into repositories
+func discoverRepos(root string) ([]Repo, error) {
+	var repos []Repo
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // unreadable directories are skipped
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			repos = append(repos, Repo{Name: rel, Path: path})
+			return filepath.SkipDir
+		}
+		if rel != "." && (strings.HasPrefix(d.Name(), ".") || strings.Count(rel, string(filepath.Separator)) >= discoverDepth-1) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover repositories: %v", err)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// repoResult is the output of a command run in one repository
+type repoResult struct {
+	stdout, stderr []byte
+	exitCode       int
+	done           chan struct{}
+}
+
+// RunInRepos runs the current command again in every selected repository, with the
+// same options apart from the repository selection. Up to Jobs repositories run at
+// once; results are merged in repository order as they complete. Text output lines
+// are prefixed with the repository name, CSV rows get a leading repo column and JSON
+// results are wrapped in a "repos" array.
+func RunInRepos(cmd *Command, opts RepoOptions, format string) int {
+	repos, err := opts.ResolveRepos()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to locate git-tools binary: %v\n", err)
+		return ExitError
+	}
+	args := append([]string{cmd.Name}, cmd.ArgsWithout(repoFlags...)...)
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	results := make([]*repoResult, len(repos))
+	slots := make(chan struct{}, jobs)
+	for i, repo := range repos {
+		result := &repoResult{done: make(chan struct{})}
+		results[i] = result
+		go func(repo Repo) {
+			slots <- struct{}{}
+			defer func() { <-slots; close(result.done) }()
+			result.stdout, result.stderr, result.exitCode = runInRepo(exe, args, repo.Path)
+		}(repo)
+	}
+
+	merger := newRepoMerger(format, os.Stdout)
+	exitCode := ExitOK
+	for i, repo := range repos {
+		result := results[i]
+		<-result.done
+		for _, line := range splitLines(result.stderr) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", repo.Name, line)
+		}
+		if result.exitCode != ExitOK {
+			exitCode = ExitError
+		}
+		if err := merger.add(repo, result); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Error: %v\n", repo.Name, err)
+			exitCode = ExitError
+		}
+	}
+	if err := merger.finish(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	return exitCode
+}
+
+// runInRepo runs git-tools with args in dir and returns its output and exit code
+func runInRepo(exe string, args []string, dir string) ([]byte, []byte, int) {
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(exe, args...)
+	c.Dir = dir
+	c.Env = append(os.Environ(), cliModeEnv+"=1")
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return stdout.Bytes(), stderr.Bytes(), exitErr.ExitCode()
+		}
+		fmt.Fprintf(&stderr, "Error: %v\n", err)
+		return stdout.Bytes(), stderr.Bytes(), ExitError
+	}
+	return stdout.Bytes(), stderr.Bytes(), ExitOK
+}
+
+func splitLines(output []byte) []string {
+	text := strings.TrimRight(string(output), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// repoMerger combines the output of a command from several repositories
+type repoMerger struct {
+	format string
+	w      io.Writer
+	csv    *csv.Writer
+	header bool // the CSV header was written
+	json   []jsonRepoResult
+}
+
+// jsonRepoResult is the JSON representation of a command's result in one repository
+type jsonRepoResult struct {
+	Repo     string          `json:"repo"`
+	Path     string          `json:"path"`
+	ExitCode int             `json:"exitCode"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func newRepoMerger(format string, w io.Writer) *repoMerger {
+	return &repoMerger{format: format, w: w, csv: csv.NewWriter(w)}
+}
+
+func (m *repoMerger) add(repo Repo, result *repoResult) error {
+	switch m.format {
+	case "json":
+		r := jsonRepoResult{Repo: repo.Name, Path: repo.Path, ExitCode: result.exitCode,
+			Error: strings.TrimSpace(string(result.stderr))}
+		if output := bytes.TrimSpace(result.stdout); len(output) > 0 {
+			if !json.Valid(output) {
+				return fmt.Errorf("invalid JSON output")
+			}
+			r.Result = output
+		}
+		m.json = append(m.json, r)
+	case "csv":
+		rows, err := csv.NewReader(bytes.NewReader(result.stdout)).ReadAll()
+		if err != nil {
+			return fmt.Errorf("invalid CSV output: %v", err)
+		}
+		for i, row := range rows {
+			if i == 0 {
+				if !m.header {
+					m.csv.Write(append([]string{"repo"}, row...))
+					m.header = true
+				}
+				continue
+			}
+			m.csv.Write(append([]string{repo.Name}, row...))
+		}
+		m.csv.Flush()
+		return m.csv.Error()
+	default:
+		for _, line := range splitLines(result.stdout) {
+			fmt.Fprintf(m.w, "%s%s:%s %s\n", ColorCyan, repo.Name, ColorReset, line)
+		}
+	}
+	return nil
+}
+
+func (m *repoMerger) finish() error {
+	if m.format != "json" {
+		return nil
+	}
+	result := struct {
+		Repos []jsonRepoResult `json:"repos"`
+	}{Repos: m.json}
+	if result.Repos == nil {
+		result.Repos = []jsonRepoResult{}
+	}
+	encoder := json.NewEncoder(m.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
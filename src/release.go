@@ -0,0 +1,177 @@
+package gittools
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReleaseOptions selects the tags and branches grep-branch treats as releases
+type ReleaseOptions struct {
+	TagPatterns    []string // globs matching release tag names, e.g. "v*"
+	BranchPatterns []string // globs matching release branch names, e.g. "release/*"
+	Prereleases    bool     // also consider tags such as v2.0.0-rc.1
+}
+
+// SemVer is a version parsed from a tag name. Missing minor and patch numbers
+// count as zero, so "v2.3" sorts as 2.3.0.
+type SemVer struct {
+	Major, Minor, Patch int
+	Prerelease          string // "rc.1" in 2.0.0-rc.1; empty for releases
+}
+
+// ParseVersion extracts a version from a tag name such as "v2.3.1", "release-2.3"
+// or "2.0.0-rc.1+build.5"; anything before the first digit is ignored
+func ParseVersion(name string) (SemVer, bool) {
+	start := strings.IndexAny(name, "0123456789")
+	if start < 0 {
+		return SemVer{}, false
+	}
+	s := name[start:]
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i] // build metadata does not affect ordering
+	}
+	var v SemVer
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.Prerelease = s[:i], s[i+1:]
+		if v.Prerelease == "" {
+			return SemVer{}, false
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return SemVer{}, false
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return SemVer{}, false
+		}
+		*numbers[i] = n
+	}
+	return v, true
+}
+
+// Compare returns -1, 0 or 1 as v sorts before, with or after other, following the
+// semver precedence rules
+func (v SemVer) Compare(other SemVer) int {
+	for _, d := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if d[0] != d[1] {
+			if d[0] < d[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1 // a release sorts after its pre-releases
+	case other.Prerelease == "":
+		return -1
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+// comparePrerelease compares dot-separated pre-release identifiers: numeric ones
+// numerically and below alphanumeric ones, which compare as text
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// matchesGlob reports whether name matches one of the glob patterns
+func matchesGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseRefs returns the release tags and branches among refs; remote-tracking
+// branches match the patterns without their remote name
+func releaseRefs(refs []Ref, opts ReleaseOptions) []Ref {
+	var releases []Ref
+	for _, ref := range refs {
+		switch ref.Kind {
+		case RefTag:
+			v, ok := ParseVersion(ref.Name)
+			if ok && (opts.Prereleases || v.Prerelease == "") && matchesGlob(opts.TagPatterns, ref.Name) {
+				releases = append(releases, ref)
+			}
+		case RefLocal:
+			if matchesGlob(opts.BranchPatterns, ref.Name) {
+				releases = append(releases, ref)
+			}
+		case RefRemote:
+			if _, branch, ok := strings.Cut(ref.Name, "/"); ok && matchesGlob(opts.BranchPatterns, branch) {
+				releases = append(releases, ref)
+			}
+		}
+	}
+	return releases
+}
+
+// addReleases sets the first release tag and the release branches of every match
+func addReleases(matches []GrepMatch, opts ReleaseOptions) error {
+	refs, err := listRefs(RefSelection{Branches: true, Remotes: true, Tags: true})
+	if err != nil {
+		return err
+	}
+	releases := releaseRefs(refs, opts)
+	hashes := make([]string, len(matches))
+	for i, match := range matches {
+		hashes[i] = match.Hash
+	}
+	containing, err := containingRefs(hashes, releases)
+	if err != nil {
+		return err
+	}
+
+	for i := range matches {
+		match := &matches[i]
+		var first SemVer
+		for _, ref := range containing[match.Hash] {
+			if ref.Kind != RefTag {
+				match.ReleaseBranches = append(match.ReleaseBranches, ref.Name)
+				continue
+			}
+			v, _ := ParseVersion(ref.Name)
+			if match.FirstRelease == "" || v.Compare(first) < 0 || v.Compare(first) == 0 && ref.Name < match.FirstRelease {
+				match.FirstRelease, first = ref.Name, v
+			}
+		}
+	}
+	return nil
+}
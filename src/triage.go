@@ -0,0 +1,156 @@
+package gittools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Triage decisions live in <git-common-dir>/git-tools/triage.json, by target branch
+// and then by commit hash: a commit skipped for one release branch may still be
+// needed on another.
+const triageFile = "triage.json"
+
+// Triage statuses
+const (
+	TriageSkip          = "skip"           // not to be ported
+	TriageNeedsBackport = "needs-backport" // to be ported
+	TriageBackported    = "backported"     // ported by hand, as Triage.Commit
+)
+
+var triageStatuses = []string{TriageSkip, TriageNeedsBackport, TriageBackported}
+
+// triageStrategy is the Equivalent.Strategy of commits triaged as backported
+const triageStrategy = "triage"
+
+// Triage is the decision taken on a commit missing from a branch
+type Triage struct {
+	Status  string `json:"status,omitempty"`
+	Commit  string `json:"commit,omitempty"` // the manual backport, for TriageBackported
+	Note    string `json:"note,omitempty"`
+	Updated string `json:"updated,omitempty"` // YYYY-MM-DD
+}
+
+// label describes the decision briefly, e.g. "backported as 1a2b3c4d"
+func (tr Triage) label() string {
+	if tr.Status == TriageBackported && tr.Commit != "" {
+		return fmt.Sprintf("%s as %s", tr.Status, shortHash(tr.Commit))
+	}
+	return tr.Status
+}
+
+// TriageStore holds the triage decisions of the repository
+type TriageStore struct {
+	path    string
+	entries map[string]map[string]Triage // by branch2, then by commit hash
+}
+
+// OpenTriageStore loads the triage decisions of the current repository
+func OpenTriageStore() (*TriageStore, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	s := &TriageStore{path: filepath.Join(dir, triageFile)}
+	return s, s.load()
+}
+
+func (s *TriageStore) load() error {
+	s.entries = make(map[string]map[string]Triage)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read triage: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return fmt.Errorf("failed to read %s: %v", s.path, err)
+	}
+	return nil
+}
+
+// Get returns the decision on a commit missing from branch2
+func (s *TriageStore) Get(branch2, hash string) (Triage, bool) {
+	triage, ok := s.entries[branch2][hash]
+	return triage, ok
+}
+
+// Set records the decision on a commit missing from branch2; an empty decision
+// removes it. The file is read again under the cache lock first so that decisions
+// taken in another session are kept.
+func (s *TriageStore) Set(branch2, hash string, triage Triage) error {
+	unlock, err := lockCache(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if triage.Status == "" && triage.Note == "" {
+		delete(s.entries[branch2], hash)
+		if len(s.entries[branch2]) == 0 {
+			delete(s.entries, branch2)
+		}
+	} else {
+		triage.Updated = time.Now().Format("2006-01-02")
+		if s.entries[branch2] == nil {
+			s.entries[branch2] = make(map[string]Triage)
+		}
+		s.entries[branch2][hash] = triage
+	}
+
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to save triage: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to save triage: %v", err)
+	}
+	return nil
+}
+
+// applyTriage takes the decisions for branch2 into account: commits triaged as skip
+// leave the missing commits, commits backported by hand become ported, and the
+// decisions on the other listed commits are kept for display
+func applyTriage(comparison *Comparison, store *TriageStore, branch2 string) {
+	comparison.Triage = make(map[string]Triage)
+	missing := make([]Commit, 0, len(comparison.Missing))
+	for _, commit := range comparison.Missing {
+		triage, ok := store.Get(branch2, commit.Hash)
+		if !ok {
+			missing = append(missing, commit)
+			continue
+		}
+		comparison.Triage[commit.Hash] = triage
+		switch {
+		case triage.Status == TriageSkip:
+			comparison.Skipped = append(comparison.Skipped, commit)
+		case triage.Status == TriageBackported && triage.Commit != "":
+			equivalent := Equivalent{Hash: triage.Commit, Strategy: triageStrategy}
+			comparison.Ported = append(comparison.Ported, PortedCommit{Commit: commit, Equivalent: equivalent})
+		default:
+			missing = append(missing, commit)
+		}
+	}
+	comparison.Missing = missing
+	for _, ported := range comparison.Ported {
+		if triage, ok := store.Get(branch2, ported.Hash); ok {
+			comparison.Triage[ported.Hash] = triage
+		}
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
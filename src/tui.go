@@ -1,73 +1,144 @@
 package gittools
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/jroimartin/gocui"
 )
 
 type TUI struct {
-	gui     *gocui.Gui
-	commits []Commit
-	current int
+	gui          *gocui.Gui
+	missing      []Commit
+	ported       []Commit // commits with an equivalent on branch2
+	showPorted   bool     // list the ported commits instead of the missing ones
+	all          []Commit // every missing or ported commit, as selected
+	commits      []Commit // the commits shown, narrowed by the filter
+	equivalents  map[string]Equivalent
+	compare      int // compareOff, compareSideBySide or compareInterdiff
+	preMergeBase map[string]Equivalent
+	cutoff       string
+	current      int
 	branch1 string
 	branch2 string
+	opts      CompareOptions // how the branches are compared, for reloads
+	picker    *branchPicker  // branch being picked, if any
+	reloading bool           // a comparison runs in the background
+
+	dual             bool                  // also list the commits only on branch2
+	others           []Commit              // commits only on branch2, in dual mode
+	otherCursor      int                   // selected commit of the branch2 list
+	onOther          bool                  // the patch pane shows the branch2 list's commit
+	otherEquivalents map[string]Equivalent // equivalents on branch1 of the branch2 commits
+	partners         map[string]string     // links equivalent commits of the two lists, both ways
+
+	marked   map[string]bool // commits selected for cherry-pick or export
+	applied  map[string]bool // commits cherry-picked onto branch2 from the TUI
+	visual   int             // start of the visual range selection, -1 when inactive
+	status   string          // message shown in the status line
+	statusID int             // counts messages, so a timer only clears its own
+	pick     *pickRun        // cherry-pick in progress, if any
+	popup    *popup          // dialog shown over the panes, if any
+	output   []string        // printed after the TUI closes, e.g. an exported command
+
+	search    string                     // text searched with /, highlighted in both panes
+	filter    string                     // filter entered with f
+	prompt    *prompt                    // search or filter being typed, if any
+	pathCache map[string]map[string]bool // commits touching each filtered path set
+
+	patches      *patchCache        // recently shown and prefetched patches
+	detailHash   string             // commit the detail pane shows or is loading
+	detailCancel context.CancelFunc // stops loading the detail pane and its neighbors
+
+	files       []commitFile // files changed by the commit shown
+	fileCursor  int          // selected file in the file pane
+	collapsed   map[int]bool // files whose diff is hidden, by index
+	fileLines   []int        // detail pane line where each file's diff starts
+	hunkLines   []int        // detail pane line where each hunk starts
+	detailPatch string       // patch shown, for collapsing files
+	diff        diffRenderer // colors the patches
+
+	theme  theme
+	keymap map[string][]string // keys of each action, by keyAction name
+	triage *TriageStore        // triage decisions, shown as badges
 }
 
-func FindMissingTUI(branch1, branch2 string) {
+// statusTimeout is how long a message stays in the status bar
+const statusTimeout = 5 * time.Second
+
+// TUIOptions controls how the find-missing TUI shows patches
+type TUIOptions struct {
+	WordDiff bool // highlight the words changed within modified lines
+	Syntax   bool // color code by the language of its file
+	Theme    theme
+	Keys     map[string][]string // keys of each keyAction
+}
+
+// loadTUIOptions reads the tui.* configuration
+func loadTUIOptions(cfg *Config) (TUIOptions, error) {
+	var opts TUIOptions
+	var err error
+	if opts.WordDiff, err = cfg.Bool("tui.wordDiff"); err != nil {
+		return opts, err
+	}
+	if opts.Syntax, err = cfg.Bool("tui.syntax"); err != nil {
+		return opts, err
+	}
+	if opts.Theme, err = loadTheme(cfg); err != nil {
+		return opts, err
+	}
+	opts.Keys, err = loadKeymap(cfg)
+	return opts, err
+}
+
+// FindMissingTUI browses the commits of branch1 missing from branch2 and returns the
+// exit code
+func FindMissingTUI(branch1, branch2 string, opts CompareOptions, tuiOpts TUIOptions) int {
 	// Check if we're in a Git repository
 	if !IsGitRepo() {
-		fmt.Printf("Error: Not in a Git repository\n")
-		return
+		fmt.Fprintf(os.Stderr, "Error: Not in a Git repository\n")
+		return ExitError
 	}
 
 	// Check if branches exist
 	if !BranchExists(branch1) {
-		fmt.Printf("Error: Branch '%s' does not exist\n", branch1)
-		return
+		fmt.Fprintf(os.Stderr, "Error: Branch '%s' does not exist\n", branch1)
+		return ExitError
 	}
 
 	if !BranchExists(branch2) {
-		fmt.Printf("Error: Branch '%s' does not exist\n", branch2)
-		return
-	}
-
-	// Get commits that are in branch1 but not in branch2 (by hash)
-	missingCommits, err := getMissingCommits(branch1, branch2)
-	if err != nil {
-		fmt.Printf("Error getting missing commits: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "Error: Branch '%s' does not exist\n", branch2)
+		return ExitError
 	}
 
-	// Get all commit subjects from branch2 for subject-based comparison (normalized)
-	branch2Subjects, err := getAllSubjects(branch2)
+	comparison, err := compareBranches(branch1, branch2, opts)
 	if err != nil {
-		fmt.Printf("Error getting subjects from branch2: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
 	}
 
-	// Filter missingCommits: only keep those whose normalized subject does NOT exist in branch2
-	filteredCommits := make([]Commit, 0, len(missingCommits))
-	for _, commit := range missingCommits {
-		normSubj := NormalizeSubject(commit.Subject)
-		if _, ok := branch2Subjects[normSubj]; !ok {
-			filteredCommits = append(filteredCommits, commit)
-		}
-	}
-
-	if len(filteredCommits) == 0 {
+	if len(comparison.Missing) == 0 && len(comparison.Ported) == 0 {
 		fmt.Printf("No missing commits found. Branch '%s' is up to date with '%s'.\n", branch2, branch1)
-		return
+		return ExitOK
 	}
 
 	// Start TUI
-	startTUI(filteredCommits, branch1, branch2)
+	return startTUI(comparison, branch1, branch2, opts, tuiOpts)
 }
 
-func startTUI(commits []Commit, branch1, branch2 string) {
+func startTUI(comparison *Comparison, branch1, branch2 string, compareOpts CompareOptions, opts TUIOptions) int {
+	triage, err := OpenTriageStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
 	g, err := gocui.NewGui(gocui.OutputNormal)
 	if err != nil {
 		log.Panicln(err)
@@ -77,14 +148,26 @@ func startTUI(commits []Commit, branch1, branch2 string) {
 	// Enable colors and mouse support
 	g.Cursor = true
 	g.Mouse = true
+	g.InputEsc = true // Esc on its own cancels a range or leaves a dialog
 
 	tui := &TUI{
-		gui:     g,
-		commits: commits,
-		current: 0,
-		branch1: branch1,
-		branch2: branch2,
-	}
+		gui:          g,
+		current:      0,
+		branch1:      branch1,
+		branch2:      branch2,
+		opts:         compareOpts,
+		marked:       make(map[string]bool),
+		applied:      make(map[string]bool),
+		visual:       -1,
+		patches:      newPatchCache(patchCacheSize),
+		collapsed:    make(map[int]bool),
+		diff:         diffRenderer{theme: opts.Theme, wordDiff: opts.WordDiff, syntax: opts.Syntax},
+		theme:        opts.Theme,
+		keymap:       opts.Keys,
+		triage:       triage,
+	}
+	tui.setComparison(comparison)
+	tui.commits = tui.all
 
 	g.SetManagerFunc(tui.layout)
 	
@@ -95,37 +178,70 @@ func startTUI(commits []Commit, branch1, branch2 string) {
 	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
 		log.Panicln(err)
 	}
+	tui.cancelDetail()
+	g.Close()
+	for _, line := range tui.output {
+		fmt.Println(line)
+	}
+	return ExitOK
+}
+
+// setComparison lists the missing or ported commits of a comparison
+func (t *TUI) setComparison(comparison *Comparison) {
+	t.missing, t.ported = comparison.Missing, nil
+	t.equivalents = make(map[string]Equivalent)
+	t.preMergeBase, t.cutoff = comparison.PreMergeBase, comparison.Cutoff
+	for _, ported := range comparison.Ported {
+		t.ported = append(t.ported, ported.Commit)
+		t.equivalents[ported.Hash] = ported.Equivalent
+	}
+	for hash, equivalent := range comparison.PreMergeBase {
+		t.equivalents[hash] = equivalent
+	}
+	switch {
+	case len(t.missing) == 0:
+		// Nothing to port; the ported commits can still be reviewed
+		t.showPorted = true
+	case len(t.ported) == 0:
+		t.showPorted = false
+	}
+	t.all = t.missing
+	switch {
+	case t.dual:
+		t.all = comparison.Commits
+	case t.showPorted:
+		t.all = t.ported
+	}
 }
 
 func (t *TUI) layout(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 	
-	// Header view - make it taller and enable wrapping for help text
-	headerHeight := 3
-	if maxX < 100 { // For narrow terminals, make header even taller
-		headerHeight = 4
-	}
+	// Header view - the title only; ? lists the keys
+	headerHeight := 2
 	if v, err := g.SetView("header", 0, 0, maxX-1, headerHeight); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
 		v.Frame = false
-		v.Wrap = true  // Enable text wrapping
-		fmt.Fprintf(v, "Git Tools - Missing Commits: '%s' -> '%s' (%d commits)\n", 
-			t.branch1, t.branch2, len(t.commits))
-		fmt.Fprintf(v, "Controls: q:quit ↑↓/jk:navigate ←→/hl:scroll Enter:focus PgUp/PgDn/Space:patch")
 	}
+	t.renderHeader()
 
 	// Commit list view (left side) - adjust for new header height
-	listWidth := maxX / 2
-	if v, err := g.SetView("list", 0, headerHeight+1, listWidth, maxY-1); err != nil {
+	listWidth := t.listWidth(maxX)
+	filesTop := headerHeight + 1 + (maxY-headerHeight-3)*3/5
+	listBottom := filesTop - 1
+	if t.dual {
+		listBottom = (headerHeight + filesTop) / 2 // the branch2 list takes the rest
+	}
+	if v, err := g.SetView("list", 0, headerHeight+1, listWidth, listBottom); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
 		v.Title = "Commits"
 		v.Highlight = true
-		v.SelBgColor = gocui.ColorGreen
-		v.SelFgColor = gocui.ColorBlack
+		v.SelBgColor = t.theme.selection.bg
+		v.SelFgColor = t.theme.selection.fg
 		v.Wrap = false  // Disable wrapping for horizontal scrolling
 		t.updateCommitList(v)
 		t.setCursor(v, t.current)
@@ -135,12 +251,28 @@ func (t *TUI) layout(g *gocui.Gui) error {
 		}
 	}
 
+	if err := t.layoutOthers(g, listWidth, listBottom+1, filesTop-1); err != nil {
+		return err
+	}
+
+	// File pane (below the list) - the files changed by the commit shown
+	if v, err := g.SetView("files", 0, filesTop, listWidth, maxY-2); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = "Files"
+		v.Highlight = true
+		v.SelBgColor = t.theme.selection.bg
+		v.SelFgColor = t.theme.selection.fg
+		v.Wrap = false
+	}
+
 	// Detail view (right side) - adjust for new header height
-	if v, err := g.SetView("detail", listWidth+1, headerHeight+1, maxX-1, maxY-1); err != nil {
+	if v, err := g.SetView("detail", listWidth+1, headerHeight+1, maxX-1, maxY-2); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
-		v.Title = "Commit Details (git show)"
+		v.Title = t.detailTitle()
 		v.Wrap = false
 		v.Autoscroll = false
 		v.Frame = true
@@ -149,125 +281,230 @@ func (t *TUI) layout(g *gocui.Gui) error {
 		}
 	}
 
-	return nil
-}
+	if v, err := g.SetView("status", -1, maxY-2, maxX, maxY); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Frame = false
+	}
+	t.renderStatus()
 
-func (t *TUI) updateCommitList(v *gocui.View) {
-	v.Clear()
-	for _, commit := range t.commits {
-		fmt.Fprintf(v, "%s %s (%s, %s)\n", commit.Hash[:8], 
-			commit.Subject, commit.Author, commit.Date)
+	if err := t.layoutPicker(g); err != nil {
+		return err
+	}
+	if err := t.layoutPrompt(g); err != nil {
+		return err
 	}
+	return t.layoutPopups(g)
 }
 
-func (t *TUI) setCursor(v *gocui.View, line int) {
-	if err := v.SetCursor(0, line); err != nil {
-		// If we can't set cursor, try setting origin
-		v.SetOrigin(0, 0)
-		v.SetCursor(0, line)
+// listWidth returns the width of the commit list; the detail pane gets the rest
+func (t *TUI) listWidth(maxX int) int {
+	if t.compare != compareOff {
+		return maxX / 3 // leave room for two patches side by side
 	}
+	return maxX / 2
 }
 
-func (t *TUI) updateCommitDetail(v *gocui.View, index int) {
-	v.Clear()
-	v.SetOrigin(0, 0) // Reset scroll position when switching commits
-	if index >= len(t.commits) {
-		return
-	}
-	
-	commit := t.commits[index]
-	
-	// Get full commit with patch (like git log -p) with color
-	fullPatch, err := getCommitFullPatch(commit.Hash)
+func (t *TUI) renderHeader() {
+	v, err := t.gui.View("header")
 	if err != nil {
-		fmt.Fprintf(v, "Error getting commit details: %v", err)
 		return
 	}
-	
-	// Display the full colored patch
-	fmt.Fprint(v, fullPatch)
-	
-	// Add cherry-pick instruction at the end
-	fmt.Fprintf(v, "\n%s--- Cherry-pick command ---%s\n", "\033[1;36m", "\033[0m")
-	fmt.Fprintf(v, "%sgit cherry-pick %s%s\n", "\033[1;32m", commit.Hash, "\033[0m")
-}
-
-func (t *TUI) setKeybindings() error {
-	if err := t.gui.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, quit); err != nil {
-		return err
-	}
-	if err := t.gui.SetKeybinding("", 'q', gocui.ModNone, quit); err != nil {
-		return err
-	}
-	
-	// Global Page Up/Down for patch view scrolling (works from any pane)
-	if err := t.gui.SetKeybinding("", gocui.KeyPgup, gocui.ModNone, t.pageUpPatch); err != nil {
-		return err
-	}
-	if err := t.gui.SetKeybinding("", gocui.KeyPgdn, gocui.ModNone, t.pageDownPatch); err != nil {
-		return err
+	v.Clear()
+	count := fmt.Sprintf("%d commits", len(t.commits))
+	if len(t.commits) != len(t.all) {
+		count = fmt.Sprintf("%d of %d commits", len(t.commits), len(t.all))
 	}
-	if err := t.gui.SetKeybinding("", gocui.KeySpace, gocui.ModNone, t.pageDownPatch); err != nil {
-		return err
+	kind := "Missing"
+	if t.showPorted {
+		kind = "Ported"
 	}
-	
-	// List navigation
-	if err := t.gui.SetKeybinding("list", gocui.KeyArrowUp, gocui.ModNone, t.cursorUp); err != nil {
-		return err
+	title := fmt.Sprintf("Git Tools - %s Commits: '%s' -> '%s' (%s)", kind, t.branch1, t.branch2, count)
+	if t.dual {
+		title = fmt.Sprintf("Git Tools - Diverged Commits: %s only on '%s', %d only on '%s'",
+			count, t.branch1, len(t.others), t.branch2)
 	}
-	if err := t.gui.SetKeybinding("list", gocui.KeyArrowDown, gocui.ModNone, t.cursorDown); err != nil {
-		return err
+	if t.reloading {
+		title += " - comparing..."
 	}
-	if err := t.gui.SetKeybinding("list", 'k', gocui.ModNone, t.cursorUp); err != nil {
-		return err
+	fmt.Fprint(v, t.theme.header.paint(title))
+}
+
+// setStatus shows a message in the status line until the next one, or for
+// statusTimeout
+func (t *TUI) setStatus(format string, args ...interface{}) {
+	t.status = fmt.Sprintf(format, args...)
+	t.statusID++
+	id := t.statusID
+	time.AfterFunc(statusTimeout, func() {
+		t.gui.Update(func(g *gocui.Gui) error {
+			if t.statusID == id {
+				t.status = ""
+				t.renderStatus()
+			}
+			return nil
+		})
+	})
+	t.renderStatus()
+}
+
+// renderStatus shows the message or prompt on the left of the status bar and the
+// selected commit on the right
+func (t *TUI) renderStatus() {
+	v, err := t.gui.View("status")
+	if err != nil {
+		return
 	}
-	if err := t.gui.SetKeybinding("list", 'j', gocui.ModNone, t.cursorDown); err != nil {
-		return err
+	v.Clear()
+	if t.prompt != nil {
+		fmt.Fprint(v, t.prompt.text()) // the input is drawn after it
+		return
 	}
-	// Horizontal scrolling for long commit messages
-	if err := t.gui.SetKeybinding("list", gocui.KeyArrowLeft, gocui.ModNone, t.scrollListLeft); err != nil {
-		return err
+	status := t.status
+	if status == "" && len(t.marked) > 0 {
+		status = fmt.Sprintf("%d commit(s) marked", len(t.marked))
 	}
-	if err := t.gui.SetKeybinding("list", gocui.KeyArrowRight, gocui.ModNone, t.scrollListRight); err != nil {
-		return err
+	if t.visual >= 0 {
+		status = fmt.Sprintf("-- VISUAL -- %d commit(s); v: mark range, Esc: cancel", max(t.current, t.visual)-min(t.current, t.visual)+1)
 	}
-	if err := t.gui.SetKeybinding("list", 'h', gocui.ModNone, t.scrollListLeft); err != nil {
-		return err
+	info := t.statusInfo()
+	width, _ := v.Size()
+	space := width - utf8.RuneCountInString(info) - 1
+	if n := utf8.RuneCountInString(status); n > space {
+		status = string([]rune(status)[:max(0, space-3)]) + "..."
 	}
-	if err := t.gui.SetKeybinding("list", 'l', gocui.ModNone, t.scrollListRight); err != nil {
-		return err
+	fmt.Fprintf(v, "%s%*s", status, width-utf8.RuneCountInString(status), info)
+}
+
+// statusInfo describes the selected commit and its position in the list
+func (t *TUI) statusInfo() string {
+	var parts []string
+	if t.pick != nil && !t.pick.running {
+		parts = append(parts, "cherry-pick stopped ("+t.keysOf("cherry-pick")+": resume)")
+	}
+	if t.onOther && t.otherCursor < len(t.others) {
+		parts = append(parts, t.otherClassification(t.others[t.otherCursor]))
+		parts = append(parts, fmt.Sprintf("%d/%d", t.otherCursor+1, len(t.others)))
+	} else if t.current < len(t.commits) {
+		parts = append(parts, t.classification(t.commits[t.current]))
+		position := fmt.Sprintf("%d/%d", t.current+1, len(t.commits))
+		if len(t.commits) != len(t.all) {
+			position += fmt.Sprintf(" of %d", len(t.all))
+		}
+		parts = append(parts, position)
 	}
-	if err := t.gui.SetKeybinding("list", gocui.KeyEnter, gocui.ModNone, t.showCommit); err != nil {
-		return err
+	if help := t.keysOf("help"); help != "" {
+		parts = append(parts, help+":help")
 	}
-	
-	// Detail view navigation (line by line)
-	if err := t.gui.SetKeybinding("detail", gocui.KeyArrowUp, gocui.ModNone, t.scrollDetailUp); err != nil {
-		return err
+	return strings.Join(parts, " | ")
+}
+
+// classification says how the commit relates to branch2
+func (t *TUI) classification(commit Commit) string {
+	var class string
+	if equivalent, ok := t.preMergeBase[commit.Hash]; ok {
+		class = fmt.Sprintf("missing; %s match %s before the cut-off", equivalent.Strategy, equivalent.Hash[:8])
+	} else if equivalent, ok := t.equivalents[commit.Hash]; ok {
+		class = fmt.Sprintf("ported as %s (%s)", equivalent.Hash[:8], equivalent.Strategy)
+	} else {
+		class = "missing"
+	}
+	switch {
+	case t.applied[commit.Hash]:
+		class += ", cherry-picked"
+	case t.marked[commit.Hash]:
+		class += ", marked"
+	}
+	if triage, ok := t.triageOf(commit.Hash); ok && triage.Status != "" {
+		class += "; triaged " + triage.label()
+	}
+	return class
+}
+
+func (t *TUI) updateCommitList(v *gocui.View) {
+	v.Clear()
+	h := t.highlighter()
+	for i, commit := range t.commits {
+		mark := " "
+		switch {
+		case t.applied[commit.Hash]:
+			mark = "+" // cherry-picked from the TUI
+		case t.marked[commit.Hash] || t.inVisualRange(i):
+			mark = "*"
+		}
+		marker := " "
+		if _, ok := t.preMergeBase[commit.Hash]; ok {
+			marker = "~" // subject only matched history before the cut-off
+		} else if t.partners[commit.Hash] != "" {
+			marker = "=" // listed with its equivalent in dual mode
+		}
+		fmt.Fprintf(v, "%s%s%s %s%s (%s, %s)\n", mark, marker, commit.Hash[:8], t.triageBadge(commit.Hash),
+			h.highlight(commit.Subject, ""), h.highlight(commit.Author, ""), commit.Date)
 	}
-	if err := t.gui.SetKeybinding("detail", gocui.KeyArrowDown, gocui.ModNone, t.scrollDetailDown); err != nil {
-		return err
+}
+
+// refreshList redraws the commit list, keeping the cursor and scroll position
+func (t *TUI) refreshList() {
+	v, err := t.gui.View("list")
+	if err != nil {
+		return
 	}
-	if err := t.gui.SetKeybinding("detail", 'k', gocui.ModNone, t.scrollDetailUp); err != nil {
+	ox, oy := v.Origin()
+	cx, cy := v.Cursor()
+	t.updateCommitList(v)
+	v.SetOrigin(ox, oy)
+	v.SetCursor(cx, cy)
+	t.renderStatus()
+}
+
+// setCursor moves the cursor to a line, scrolling the view to keep it visible
+func (t *TUI) setCursor(v *gocui.View, line int) {
+	ox, oy := v.Origin()
+	_, height := v.Size()
+	if line < oy {
+		oy = line
+	} else if height > 0 && line >= oy+height {
+		oy = line - height + 1
+	}
+	v.SetOrigin(ox, oy)
+	v.SetCursor(0, line-oy)
+}
+
+func (t *TUI) setKeybindings() error {
+	if err := t.gui.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, t.quit); err != nil {
 		return err
 	}
-	if err := t.gui.SetKeybinding("detail", 'j', gocui.ModNone, t.scrollDetailDown); err != nil {
+	// Keys from the keymap are bound per pane so they can be typed into the prompts
+	if err := t.setActionKeybindings(); err != nil {
 		return err
 	}
-	if err := t.gui.SetKeybinding("detail", gocui.KeyEsc, gocui.ModNone, t.backToList); err != nil {
+	if err := t.setSearchKeybindings(); err != nil {
 		return err
 	}
-	return nil
+	return t.setMarkKeybindings()
 }
 
 func quit(g *gocui.Gui, v *gocui.View) error {
 	return gocui.ErrQuit
 }
 
+// quit leaves the TUI unless a cherry-pick is running
+func (t *TUI) quit(g *gocui.Gui, v *gocui.View) error {
+	if t.pick != nil && t.pick.running {
+		t.setStatus("A cherry-pick is running; wait for it to finish")
+		return nil
+	}
+	return gocui.ErrQuit
+}
+
 func (t *TUI) cursorUp(g *gocui.Gui, v *gocui.View) error {
 	if t.current > 0 {
 		t.current--
 		t.setCursor(v, t.current)
+		if t.visual >= 0 {
+			t.refreshList()
+		}
 		if detailView, err := g.View("detail"); err == nil {
 			t.updateCommitDetail(detailView, t.current)
 		}
@@ -279,6 +516,9 @@ func (t *TUI) cursorDown(g *gocui.Gui, v *gocui.View) error {
 	if t.current < len(t.commits)-1 {
 		t.current++
 		t.setCursor(v, t.current)
+		if t.visual >= 0 {
+			t.refreshList()
+		}
 		if detailView, err := g.View("detail"); err == nil {
 			t.updateCommitDetail(detailView, t.current)
 		}
@@ -293,8 +533,13 @@ func (t *TUI) showCommit(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+// backToList returns to the list whose commit the patch pane shows
 func (t *TUI) backToList(g *gocui.Gui, v *gocui.View) error {
-	if _, err := g.SetCurrentView("list"); err != nil {
+	list := "list"
+	if t.dual && t.onOther {
+		list = "others"
+	}
+	if _, err := g.SetCurrentView(list); err != nil {
 		return err
 	}
 	return nil
@@ -354,13 +599,8 @@ func (t *TUI) pageDownPatch(g *gocui.Gui, v *gocui.View) error {
 }
 
 func getCommitFullPatch(hash string) (string, error) {
-	// Use git show with color to get full patch information
-	cmd := exec.Command("git", "show", "--color=always", "--stat", "--patch", hash)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	return string(output), nil
+	// Uncolored; diffRenderer colors it with escape sequences gocui understands
+	return getCommitFullPatchContext(context.Background(), hash)
 }
 
 func getCommitDiffStats(hash string) (string, error) {
@@ -0,0 +1,285 @@
+package gittools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// branchPicker lists the branches matching the input of the branch prompt
+type branchPicker struct {
+	side     int      // 1 or 2: the branch being replaced
+	branches []string // local, then remote-tracking branches
+	query    string
+	matches  []string // branches matching query, best first
+	cursor   int
+}
+
+// filter selects the branches matching query, keeping the selection if the query
+// did not change
+func (p *branchPicker) filter(query string) {
+	if p.matches != nil && query == p.query {
+		return
+	}
+	type match struct {
+		name  string
+		score int
+	}
+	var matches []match
+	for _, branch := range p.branches {
+		if score, ok := fuzzyScore(query, branch); ok {
+			matches = append(matches, match{branch, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return len(matches[i].name) < len(matches[j].name)
+	})
+	p.query, p.matches, p.cursor = query, []string{}, 0
+	for _, m := range matches {
+		p.matches = append(p.matches, m.name)
+	}
+}
+
+func (p *branchPicker) move(delta int) {
+	p.cursor = max(0, min(p.cursor+delta, len(p.matches)-1))
+}
+
+// fuzzyScore reports whether the characters of pattern occur in text in order,
+// ignoring case, and scores the match higher for consecutive characters and for
+// characters starting a word of the branch name
+func fuzzyScore(pattern, text string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	runes := []rune(strings.ToLower(text))
+	score, j, previous := 0, 0, -2
+	for i, r := range runes {
+		if j == len(p) {
+			break
+		}
+		if r != p[j] {
+			continue
+		}
+		score++
+		if i == previous+1 {
+			score += 2
+		}
+		if i == 0 || strings.ContainsRune("/-_.", runes[i-1]) {
+			score += 3
+		}
+		previous = i
+		j++
+	}
+	return score, j == len(p)
+}
+
+func (t *TUI) pickBranch1(g *gocui.Gui, v *gocui.View) error {
+	return t.startBranchPicker(1)
+}
+
+func (t *TUI) pickBranch2(g *gocui.Gui, v *gocui.View) error {
+	return t.startBranchPicker(2)
+}
+
+// startBranchPicker replaces branch1 or branch2 with a branch chosen by typing part
+// of its name; the arrows select among the matches
+func (t *TUI) startBranchPicker(side int) error {
+	refs, err := listRefs(RefSelection{Branches: true, Remotes: true})
+	if err != nil {
+		t.setStatus("Error: %v", err)
+		return nil
+	}
+	p := &branchPicker{side: side}
+	for _, ref := range refs {
+		p.branches = append(p.branches, ref.Name)
+	}
+	p.filter("")
+	t.picker = p
+	t.openPrompt(&prompt{
+		label: fmt.Sprintf("branch%d: ", side),
+		changed: func(text string) error {
+			p.filter(text)
+			if len(p.matches) == 0 {
+				return fmt.Errorf("no branch matches '%s'", text)
+			}
+			return nil
+		},
+		move: p.move,
+		cancel: func() {
+			t.picker = nil
+		},
+		done: func() {
+			t.picker = nil
+			t.switchBranch(side, p.matches[p.cursor])
+		},
+	})
+	return nil
+}
+
+// switchBranch compares branch instead of branch1 or branch2
+func (t *TUI) switchBranch(side int, branch string) {
+	branch1, branch2 := t.branch1, t.branch2
+	if side == 1 {
+		branch1 = branch
+	} else {
+		branch2 = branch
+	}
+	if branch1 == branch2 {
+		t.setStatus("Error: '%s' cannot be compared with itself", branch)
+		return
+	}
+	t.reload(branch1, branch2, false, t.dual)
+}
+
+// swapBranches lists what branch2 has that branch1 lacks
+func (t *TUI) swapBranches(g *gocui.Gui, v *gocui.View) error {
+	t.reload(t.branch2, t.branch1, false, t.dual)
+	return nil
+}
+
+// refresh fetches the remotes and compares the branches again
+func (t *TUI) refresh(g *gocui.Gui, v *gocui.View) error {
+	t.reload(t.branch1, t.branch2, true, t.dual)
+	return nil
+}
+
+// reload compares the branches again in the background, after fetching if asked and
+// both ways for dual mode, and then shows the result
+func (t *TUI) reload(branch1, branch2 string, fetch, dual bool) {
+	if t.pick != nil {
+		t.setStatus("Finish the cherry-pick first (c: resume)")
+		return
+	}
+	if t.reloading {
+		t.setStatus("Still comparing the branches")
+		return
+	}
+	t.reloading = true
+	t.renderHeader()
+	go func() {
+		var comparison, reverse *Comparison
+		var err error
+		if fetch {
+			err = fetchRemotes()
+		}
+		if err == nil {
+			comparison, err = compareBranches(branch1, branch2, t.opts)
+		}
+		if err == nil && dual {
+			reverse, err = compareBranches(branch2, branch1, t.opts)
+		}
+		t.gui.Update(func(g *gocui.Gui) error {
+			t.reloading = false
+			if err != nil {
+				t.renderHeader()
+				t.setStatus("Error: %v", err)
+				return nil
+			}
+			t.showComparison(comparison, reverse, branch1, branch2)
+			return nil
+		})
+	}()
+}
+
+// showComparison replaces the listed commits with those of a new comparison, and
+// shows the reverse comparison in dual mode if there is one. The filter and the marks
+// of commits still listed are kept, and so is the cursor if its commit is still listed.
+func (t *TUI) showComparison(comparison, reverse *Comparison, branch1, branch2 string) {
+	hash, index := "", t.current
+	if t.current < len(t.commits) {
+		hash = t.commits[t.current].Hash
+	}
+	t.branch1, t.branch2 = branch1, branch2
+	t.dual = reverse != nil
+	t.setComparison(comparison)
+	t.others, t.otherEquivalents, t.partners = nil, nil, nil
+	if t.dual {
+		t.setOthers(comparison, reverse)
+		if v, err := t.gui.View("others"); err == nil {
+			t.updateOtherList(v)
+		}
+	}
+	t.pathCache = nil // the paths' commits depend on the branches
+
+	listed := make(map[string]bool)
+	for _, commit := range append(append([]Commit{}, t.missing...), t.ported...) {
+		listed[commit.Hash] = true
+	}
+	for marked := range t.marked {
+		if !listed[marked] {
+			delete(t.marked, marked)
+		}
+	}
+
+	if err := t.applyFilter(t.filter); err != nil {
+		t.applyFilter("")
+	}
+	if commitIndex(t.commits, hash) < 0 && len(t.commits) > 0 {
+		t.selectCommit(min(index, len(t.commits)-1))
+	}
+
+	switch {
+	case len(t.missing) == 0 && len(t.ported) == 0:
+		t.setStatus("No missing commits: '%s' is up to date with '%s'", branch2, branch1)
+	default:
+		t.setStatus("'%s' -> '%s': %d missing, %d ported", branch1, branch2, len(t.missing), len(t.ported))
+	}
+}
+
+// fetchRemotes updates the remote-tracking branches, if the repository has remotes
+func fetchRemotes() error {
+	remotes, err := exec.Command("git", "remote").Output()
+	if err != nil || strings.TrimSpace(string(remotes)) == "" {
+		return nil
+	}
+	cmd := exec.Command("git", "fetch", "--all", "--prune", "--quiet")
+	// A password prompt would draw over the TUI
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if os.Getenv("GIT_SSH_COMMAND") == "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch failed: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// layoutPicker draws the matching branches above the prompt while a branch is picked
+func (t *TUI) layoutPicker(g *gocui.Gui) error {
+	if t.picker == nil {
+		g.DeleteView("branches")
+		return nil
+	}
+	maxX, maxY := g.Size()
+	rows := max(1, min(len(t.picker.matches), maxY/2))
+	v, err := g.SetView("branches", 0, maxY-3-rows, t.listWidth(maxX), maxY-2)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Highlight = true
+		v.SelBgColor = t.theme.selection.bg
+		v.SelFgColor = t.theme.selection.fg
+	}
+	v.Title = fmt.Sprintf("branch%d: %d of %d branches (Up/Down: select, Enter: compare)",
+		t.picker.side, len(t.picker.matches), len(t.picker.branches))
+	v.Clear()
+	for _, branch := range t.picker.matches {
+		switch branch {
+		case t.branch1:
+			fmt.Fprintf(v, "%s  (branch1)\n", branch)
+		case t.branch2:
+			fmt.Fprintf(v, "%s  (branch2)\n", branch)
+		default:
+			fmt.Fprintln(v, branch)
+		}
+	}
+	t.setCursor(v, t.picker.cursor)
+	_, err = g.SetViewOnTop("branches")
+	return err
+}
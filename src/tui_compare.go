@@ -0,0 +1,458 @@
+package gittools
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jroimartin/gocui"
+)
+
+// Compare modes of the detail pane, cycled with =
+const (
+	compareOff = iota
+	compareSideBySide
+	compareInterdiff
+)
+
+// patchFile is the part of a patch changing one file
+type patchFile struct {
+	name   string
+	header []string // diff --git, index and ---/+++ lines
+	hunks  []patchHunk
+}
+
+// patchHunk is one @@ section of a patch
+type patchHunk struct {
+	header string
+	lines  []string
+}
+
+// changes returns the added and removed lines of a hunk. Hunks are compared by their
+// changes only: a backport usually applies at other line numbers, between other context.
+func (h patchHunk) changes() string {
+	var b strings.Builder
+	for _, line := range h.lines {
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// parsePatch splits git show output with a one-line format into that line and the
+// changed files
+func parsePatch(text string) (string, []patchFile) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	title := lines[0]
+	var files []patchFile
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			name := line
+			if i := strings.LastIndex(line, " b/"); i >= 0 {
+				name = line[i+3:]
+			}
+			files = append(files, patchFile{name: name, header: []string{line}})
+		case len(files) == 0:
+			continue // blank line after the format
+		case strings.HasPrefix(line, "@@"):
+			file := &files[len(files)-1]
+			file.hunks = append(file.hunks, patchHunk{header: line})
+		default:
+			file := &files[len(files)-1]
+			if len(file.hunks) == 0 {
+				file.header = append(file.header, line)
+			} else {
+				hunk := &file.hunks[len(file.hunks)-1]
+				hunk.lines = append(hunk.lines, line)
+			}
+		}
+	}
+	return title, files
+}
+
+// maxInterdiffLines bounds the hunks of a file aligned with each other, and the lines
+// of a hunk compared line by line in the interdiff: the table of lcsDiff grows with
+// the product of both sides. Larger ones are listed in full.
+const maxInterdiffLines = 1000
+
+// diffOp is a step of an edit script: '=' keeps a[i] (equal to b[j]), '-' drops
+// a[i] and '+' inserts b[j]
+type diffOp struct {
+	kind byte
+	i, j int
+}
+
+// lcsDiff returns an edit script turning a sequence of n elements into one of m
+// elements, keeping the longest common subsequence
+func lcsDiff(n, m int, equal func(i, j int) bool) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if equal(i, j) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case equal(i, j):
+			ops = append(ops, diffOp{'=', i, j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', i, -1})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', -1, j})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', i, -1})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', -1, j})
+	}
+	return ops
+}
+
+// hunkPair is a source hunk and the target hunk it is shown against; either is nil
+// when the other side has no counterpart
+type hunkPair struct {
+	source, target *patchHunk
+}
+
+func (p hunkPair) identical() bool {
+	return p.source != nil && p.target != nil && p.source.changes() == p.target.changes()
+}
+
+// alignHunks pairs identical hunks in order, and the differing hunks between them
+// with each other
+func alignHunks(source, target []patchHunk) []hunkPair {
+	var pairs []hunkPair
+	var removed, added []*patchHunk
+	flush := func() {
+		for k := 0; k < max(len(removed), len(added)); k++ {
+			var pair hunkPair
+			if k < len(removed) {
+				pair.source = removed[k]
+			}
+			if k < len(added) {
+				pair.target = added[k]
+			}
+			pairs = append(pairs, pair)
+		}
+		removed, added = nil, nil
+	}
+	if len(source) > maxInterdiffLines || len(target) > maxInterdiffLines {
+		for i := range source {
+			removed = append(removed, &source[i])
+		}
+		for j := range target {
+			added = append(added, &target[j])
+		}
+		flush()
+		return pairs
+	}
+	sourceChanges, targetChanges := make([]string, len(source)), make([]string, len(target))
+	for i := range source {
+		sourceChanges[i] = source[i].changes()
+	}
+	for j := range target {
+		targetChanges[j] = target[j].changes()
+	}
+	ops := lcsDiff(len(source), len(target), func(i, j int) bool {
+		return sourceChanges[i] == targetChanges[j]
+	})
+	for _, op := range ops {
+		switch op.kind {
+		case '=':
+			flush()
+			pairs = append(pairs, hunkPair{&source[op.i], &target[op.j]})
+		case '-':
+			removed = append(removed, &source[op.i])
+		case '+':
+			added = append(added, &target[op.j])
+		}
+	}
+	flush()
+	return pairs
+}
+
+// filePair is a changed file of the source commit and of the target commit, either
+// nil if only one commit changes it
+type filePair struct {
+	name           string
+	source, target *patchFile
+}
+
+func alignFiles(source, target []patchFile) []filePair {
+	var pairs []filePair
+	index := make(map[string]int)
+	for i := range source {
+		index[source[i].name] = len(pairs)
+		pairs = append(pairs, filePair{name: source[i].name, source: &source[i]})
+	}
+	for i := range target {
+		if k, ok := index[target[i].name]; ok {
+			pairs[k].target = &target[i]
+		} else {
+			pairs = append(pairs, filePair{name: target[i].name, target: &target[i]})
+		}
+	}
+	return pairs
+}
+
+func (p filePair) hunks() []hunkPair {
+	var source, target []patchHunk
+	if p.source != nil {
+		source = p.source.hunks
+	}
+	if p.target != nil {
+		target = p.target.hunks
+	}
+	return alignHunks(source, target)
+}
+
+// headerOnly reports whether neither side of the pair has hunks, as for binary
+// files, pure renames and mode changes
+func (p filePair) headerOnly() bool {
+	return (p.source == nil || len(p.source.hunks) == 0) && (p.target == nil || len(p.target.hunks) == 0)
+}
+
+// headerLines returns the header of one side without its diff --git line: the index,
+// mode, rename and Binary files lines that describe a change without hunks
+func headerLines(file *patchFile) []string {
+	if file == nil {
+		return nil
+	}
+	return file.header[1:]
+}
+
+// headerIdentical reports whether both sides of a pair without hunks change the file alike
+func (p filePair) headerIdentical() bool {
+	return p.source != nil && p.target != nil &&
+		slices.Equal(headerLines(p.source), headerLines(p.target))
+}
+
+// togglePorted switches the list between the missing and the ported commits
+func (t *TUI) togglePorted(g *gocui.Gui, v *gocui.View) error {
+	if t.dual {
+		t.setStatus("The lists show missing and ported commits together; %s: one direction", t.keysOf("dual"))
+		return nil
+	}
+	t.showPorted = !t.showPorted
+	t.all = t.missing
+	if t.showPorted {
+		t.all = t.ported
+	}
+	t.current = 0
+	t.commits = nil // keeps applyFilter from looking for the cursor's commit
+	return t.applyFilter(t.filter)
+}
+
+func (t *TUI) detailTitle() string {
+	switch t.compare {
+	case compareSideBySide:
+		return "Compare: side by side (=: interdiff)"
+	case compareInterdiff:
+		return "Compare: interdiff (=: patch)"
+	}
+	return "Commit Details (git show)"
+}
+
+// toggleCompare cycles the detail pane between the patch, the side-by-side comparison
+// with the equivalent commit on branch2 and their interdiff
+func (t *TUI) toggleCompare(g *gocui.Gui, v *gocui.View) error {
+	t.compare = (t.compare + 1) % 3
+	if t.compare != compareOff && len(t.commits) > 0 {
+		if _, ok := t.equivalents[t.commits[t.current].Hash]; !ok {
+			t.setStatus("No equivalent of this commit on %s; compare mode shows the commits that have one", t.branch2)
+		}
+	}
+	if detail, err := g.View("detail"); err == nil {
+		t.updateCommitDetail(detail, t.current)
+	}
+	return nil
+}
+
+// renderComparison shows the patches of a commit and its equivalent on branch2, with
+// the hunks whose changes differ highlighted
+func (t *TUI) renderComparison(v *gocui.View, width int, commit Commit, sourcePatch, targetPatch string) {
+	v.Clear()
+	equivalent := t.equivalents[commit.Hash]
+	sourceTitle, sourceFiles := parsePatch(sourcePatch)
+	targetTitle, targetFiles := parsePatch(targetPatch)
+	files := alignFiles(sourceFiles, targetFiles)
+
+	identical, total := 0, 0
+	for _, file := range files {
+		if file.headerOnly() {
+			total++
+			if file.headerIdentical() {
+				identical++
+			}
+			continue
+		}
+		for _, pair := range file.hunks() {
+			total++
+			if pair.identical() {
+				identical++
+			}
+		}
+	}
+	th := t.theme
+	fmt.Fprintf(v, "%s %s\n", th.heading.paint(t.branch1+":"), sourceTitle)
+	fmt.Fprintf(v, "%s %s\n", th.heading.paint(t.branch2+":"), targetTitle)
+	status := th.added
+	if identical < total || total == 0 {
+		status = th.note
+	}
+	fmt.Fprintf(v, "Matched by %s; %s\n", equivalent.Strategy, status.paint(fmt.Sprintf("%d of %d hunk(s) identical", identical, total)))
+
+	if t.compare == compareInterdiff {
+		renderInterdiff(v, th, files, t.branch1, t.branch2)
+	} else {
+		renderSideBySide(v, th, width, files, t.branch1, t.branch2)
+	}
+}
+
+// renderSideBySide prints the source hunks on the left and the target hunks on the
+// right; differing hunks are marked with ! between the columns
+func renderSideBySide(w io.Writer, th theme, width int, files []filePair, branch1, branch2 string) {
+	column := max((width-3)/2, 10)
+	warning := th.warning()
+	for _, file := range files {
+		fmt.Fprintf(w, "\n%s\n", th.meta.paint(file.name))
+		switch {
+		case file.source == nil:
+			fmt.Fprintln(w, warning.paint("! only changed on "+branch2))
+		case file.target == nil:
+			fmt.Fprintln(w, warning.paint("! only changed on "+branch1))
+		}
+		if file.headerOnly() {
+			separator := " │ "
+			if !file.headerIdentical() {
+				separator = warning.paint(" ! ")
+			}
+			printColumns(w, th, column, separator, headerLines(file.source), headerLines(file.target))
+			continue
+		}
+		for _, pair := range file.hunks() {
+			separator := " │ "
+			if !pair.identical() {
+				separator = warning.paint(" ! ")
+			}
+			printColumns(w, th, column, separator, hunkLines(pair.source), hunkLines(pair.target))
+		}
+	}
+}
+
+// printColumns prints left and right next to each other, padding the shorter side
+func printColumns(w io.Writer, th theme, column int, separator string, left, right []string) {
+	for k := 0; k < max(len(left), len(right)); k++ {
+		var l, r string
+		if k < len(left) {
+			l = left[k]
+		}
+		if k < len(right) {
+			r = right[k]
+		}
+		fmt.Fprintf(w, "%s%s%s\n", th.patchColumn(l, column), separator, th.patchColumn(r, column))
+	}
+}
+
+// renderInterdiff prints, per file, the differences between the source and target
+// hunks: lines only in the source are prefixed with -, lines only in the target with +
+func renderInterdiff(w io.Writer, th theme, files []filePair, branch1, branch2 string) {
+	fmt.Fprintf(w, "%s  %s\n", th.removed.paint("- only in "+branch1), th.added.paint("+ only in "+branch2))
+	for _, file := range files {
+		fmt.Fprintf(w, "\n%s\n", th.meta.paint(file.name))
+		if file.headerOnly() {
+			if file.headerIdentical() {
+				fmt.Fprintf(w, "  %s\n", th.hunk.paint("(no hunks, identical)"))
+			} else {
+				printInterdiffLines(w, th, '-', headerLines(file.source))
+				printInterdiffLines(w, th, '+', headerLines(file.target))
+			}
+			continue
+		}
+		for _, pair := range file.hunks() {
+			switch {
+			case pair.identical():
+				fmt.Fprintf(w, "  %s\n", th.hunk.paint(pair.source.header+" (identical)"))
+			case pair.target == nil:
+				printInterdiffLines(w, th, '-', hunkLines(pair.source))
+			case pair.source == nil:
+				printInterdiffLines(w, th, '+', hunkLines(pair.target))
+			default:
+				fmt.Fprintln(w, th.note.paint("! "+pair.source.header+" | "+pair.target.header))
+				a, b := pair.source.lines, pair.target.lines
+				if len(a) > maxInterdiffLines || len(b) > maxInterdiffLines {
+					printInterdiffLines(w, th, '-', a)
+					printInterdiffLines(w, th, '+', b)
+					continue
+				}
+				for _, op := range lcsDiff(len(a), len(b), func(i, j int) bool { return a[i] == b[j] }) {
+					switch op.kind {
+					case '=':
+						fmt.Fprintf(w, "  %s\n", a[op.i])
+					case '-':
+						printInterdiffLines(w, th, '-', a[op.i:op.i+1])
+					case '+':
+						printInterdiffLines(w, th, '+', b[op.j:op.j+1])
+					}
+				}
+			}
+		}
+	}
+}
+
+func printInterdiffLines(w io.Writer, th theme, kind byte, lines []string) {
+	s := th.added
+	if kind == '-' {
+		s = th.removed
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, s.paint(string(kind)+" "+line))
+	}
+}
+
+func hunkLines(hunk *patchHunk) []string {
+	if hunk == nil {
+		return nil
+	}
+	return append([]string{hunk.header}, hunk.lines...)
+}
+
+// patchColumn colors a patch line like git does and fits it into width columns
+func (th theme) patchColumn(line string, width int) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	if utf8.RuneCountInString(line) > width {
+		line = string([]rune(line)[:width-1]) + "…"
+	}
+	padding := strings.Repeat(" ", width-utf8.RuneCountInString(line))
+	switch {
+	case strings.HasPrefix(line, "@@"):
+		return th.hunk.paint(line) + padding
+	case strings.HasPrefix(line, "+"):
+		return th.added.paint(line) + padding
+	case strings.HasPrefix(line, "-"):
+		return th.removed.paint(line) + padding
+	}
+	return line + padding
+}
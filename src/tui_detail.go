@@ -0,0 +1,339 @@
+package gittools
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"github.com/jroimartin/gocui"
+)
+
+const (
+	patchCacheSize   = 64 // patches, raw and rendered, kept in memory by the find-missing TUI
+	prefetchDistance = 2  // commits above and below the cursor loaded ahead
+)
+
+// patchCache keeps the most recently used patches, keyed by patchRequest.key(), and
+// rendered patches, keyed by patchRenderer.key(). It is safe for concurrent use.
+type patchCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *patchEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type patchEntry struct {
+	key, patch string
+}
+
+func newPatchCache(size int) *patchCache {
+	return &patchCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *patchCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*patchEntry).patch, true
+}
+
+func (c *patchCache) add(key, patch string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*patchEntry).patch = patch
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&patchEntry{key, patch})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*patchEntry).key)
+	}
+}
+
+// Kinds of git show output the detail pane uses
+const (
+	patchFull    = "full"    // the patch with stat, colored by diffRenderer
+	patchPlain   = "plain"   // uncolored, with a one-line header, for comparing patches
+	patchNumstat = "numstat" // the changed files, for the file pane
+)
+
+// patchRequest names a patch the detail pane shows
+type patchRequest struct {
+	hash string
+	kind string
+}
+
+func (r patchRequest) key() string {
+	return r.kind + ":" + r.hash
+}
+
+func loadPatch(ctx context.Context, r patchRequest) (string, error) {
+	var args []string
+	switch r.kind {
+	case patchFull:
+		return getCommitFullPatchContext(ctx, r.hash)
+	case patchPlain:
+		args = []string{"show", "--no-color", "--date=short", "--format=%h %s (%an, %ad)", "--patch", r.hash}
+	case patchNumstat:
+		args = []string{"show", "--numstat", "-z", "--format=", r.hash}
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// detailRequests returns the patches needed to show a commit: its patch and changed
+// files, or both sides of the comparison in compare mode
+func (t *TUI) detailRequests(commit Commit) []patchRequest {
+	hash := commit.Hash
+	if equivalent, ok := t.equivalents[hash]; ok && t.compare != compareOff {
+		return []patchRequest{{hash, patchPlain}, {equivalent.Hash, patchPlain}}
+	}
+	return []patchRequest{{hash, patchFull}, {hash, patchNumstat}}
+}
+
+// cachedPatches returns the requested patches if all of them are cached
+func (t *TUI) cachedPatches(requests []patchRequest) ([]string, bool) {
+	patches := make([]string, len(requests))
+	for i, r := range requests {
+		patch, ok := t.patches.get(r.key())
+		if !ok {
+			return nil, false
+		}
+		patches[i] = patch
+	}
+	return patches, true
+}
+
+// loadPatches loads the requested patches into the cache, stopping at the first error
+func (t *TUI) loadPatches(ctx context.Context, requests []patchRequest) ([]string, error) {
+	patches := make([]string, len(requests))
+	for i, r := range requests {
+		patch, ok := t.patches.get(r.key())
+		if !ok {
+			var err error
+			if patch, err = loadPatch(ctx, r); err != nil {
+				return nil, err
+			}
+			t.patches.add(r.key(), patch)
+		}
+		patches[i] = patch
+	}
+	return patches, nil
+}
+
+// patchRenderer colors full patches for the patch pane. Coloring and highlighting a
+// large patch takes long, so it is done in the background and the result cached by
+// the options used.
+type patchRenderer struct {
+	diff      diffRenderer
+	highlight *highlighter
+}
+
+// renderer returns the current rendering options, for use by any goroutine
+func (t *TUI) renderer() patchRenderer {
+	return patchRenderer{t.diff, t.highlighter()}
+}
+
+func (r patchRenderer) key(hash string) string {
+	pattern := ""
+	if r.highlight.re != nil {
+		pattern = r.highlight.re.String()
+	}
+	return fmt.Sprintf("rendered:%t:%t:%s:%s", r.diff.wordDiff, r.diff.syntax, hash, pattern)
+}
+
+// renderPatch returns the full patch of hash rendered by r, from the cache if it was
+// rendered before
+func (t *TUI) renderPatch(r patchRenderer, hash, patch string) string {
+	key := r.key(hash)
+	if rendered, ok := t.patches.get(key); ok {
+		return rendered
+	}
+	rendered := r.highlight.highlightANSI(r.diff.render(patch))
+	t.patches.add(key, rendered)
+	return rendered
+}
+
+// preparePatches loads the requested patches and renders the full patch among them
+func (t *TUI) preparePatches(ctx context.Context, r patchRenderer, requests []patchRequest) ([]string, error) {
+	patches, err := t.loadPatches(ctx, requests)
+	if err == nil && requests[0].kind == patchFull {
+		t.renderPatch(r, requests[0].hash, patches[0])
+	}
+	return patches, err
+}
+
+// updateCommitDetail shows the commit at index of the commit list
+func (t *TUI) updateCommitDetail(v *gocui.View, index int) {
+	t.showDetail(v, t.commits, index, false)
+}
+
+// updateOtherDetail shows the commit at index of the branch2 list
+func (t *TUI) updateOtherDetail(v *gocui.View, index int) {
+	t.showDetail(v, t.others, index, true)
+}
+
+// showDetail shows the commit at index of commits, from the cache or by loading it
+// in the background; the pane title says "loading..." until the patch arrives
+func (t *TUI) showDetail(v *gocui.View, commits []Commit, index int, other bool) {
+	v.Clear()
+	v.SetOrigin(0, 0) // Reset scroll position when switching commits
+	v.Title = t.detailTitle()
+	t.cancelDetail()
+	t.onOther = other
+	if index >= len(commits) {
+		t.detailHash = ""
+		return
+	}
+	t.linkPartner()
+
+	commit := commits[index]
+	if commit.Hash != t.detailHash {
+		t.collapsed = make(map[int]bool)
+		t.fileCursor = 0
+	}
+	t.detailHash = commit.Hash
+	t.setFiles(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.detailCancel = cancel
+	requests := t.detailRequests(commit)
+	renderer := t.renderer()
+	if patches, ok := t.cachedPatches(requests); ok {
+		if _, rendered := t.patches.get(renderer.key(commit.Hash)); rendered || requests[0].kind != patchFull {
+			t.renderDetail(v, commit, requests, patches, nil)
+			t.prefetch(ctx, commits, index)
+			return
+		}
+	}
+
+	v.Title = t.detailTitle() + " - loading..."
+	go func() {
+		patches, err := t.preparePatches(ctx, renderer, requests)
+		if ctx.Err() != nil {
+			return // the cursor moved on
+		}
+		t.gui.Update(func(g *gocui.Gui) error {
+			v, viewErr := g.View("detail")
+			if viewErr != nil || t.detailHash != commit.Hash || ctx.Err() != nil {
+				return nil
+			}
+			v.Title = t.detailTitle()
+			t.renderDetail(v, commit, requests, patches, err)
+			t.prefetch(ctx, commits, index)
+			return nil
+		})
+	}()
+}
+
+// renderDetail shows a loaded commit and its files, or its comparison when both
+// sides were requested
+func (t *TUI) renderDetail(v *gocui.View, commit Commit, requests []patchRequest, patches []string, err error) {
+	t.fileLines, t.hunkLines = nil, nil
+	switch {
+	case err != nil:
+		t.renderCommitDetail(v, commit, "", err)
+	case requests[0].kind == patchPlain:
+		// The pane may not have been resized for compare mode yet
+		maxX, _ := t.gui.Size()
+		t.renderComparison(v, maxX-t.listWidth(maxX)-3, commit, patches[0], patches[1])
+	default:
+		t.setFiles(parseNumstat(patches[1]))
+		t.detailPatch = patches[0]
+		t.renderCommitDetail(v, commit, patches[0], nil)
+	}
+}
+
+// renderCommitDetail prints the patch of a commit with the pre-merge-base note and
+// the cherry-pick command
+func (t *TUI) renderCommitDetail(v *gocui.View, commit Commit, patch string, err error) {
+	v.Clear()
+	if err != nil {
+		fmt.Fprintf(v, "Error getting commit details: %v", err)
+		return
+	}
+
+	line := 0
+	if equivalent, ok := t.preMergeBase[commit.Hash]; ok {
+		fmt.Fprintf(v, "%s\n\n", t.diff.theme.note.paint("Note: "+preMergeBaseNote(equivalent, t.cutoff)))
+		line += 2
+	} else if equivalent, ok := t.equivalents[commit.Hash]; ok {
+		note := fmt.Sprintf("Note: %s matches %s on %s; press = to compare", equivalent.Strategy, equivalent.Hash[:8], t.branch2)
+		fmt.Fprintf(v, "%s\n\n", t.diff.theme.note.paint(note))
+		line += 2
+	} else if equivalent, ok := t.otherEquivalents[commit.Hash]; ok {
+		note := fmt.Sprintf("Note: %s matches %s on %s", equivalent.Strategy, equivalent.Hash[:8], t.branch1)
+		fmt.Fprintf(v, "%s\n\n", t.diff.theme.note.paint(note))
+		line += 2
+	}
+
+	if triage, ok := t.triageOf(commit.Hash); ok && !t.onOther {
+		if triage.Status != "" {
+			fmt.Fprintf(v, "%s\n", t.diff.theme.note.paint("Triage: "+triage.label()))
+			line++
+		}
+		if triage.Note != "" {
+			fmt.Fprintf(v, "%s\n", t.diff.theme.note.paint("Triage note: "+triage.Note))
+			line++
+		}
+		fmt.Fprintln(v)
+		line++
+	}
+
+	// Display the patch, recording where files and hunks start
+	t.writePatch(v, line, t.renderPatch(t.renderer(), commit.Hash, patch))
+
+	// Add cherry-pick instruction at the end
+	fmt.Fprintf(v, "\n%s\n", t.diff.theme.heading.paint("--- Cherry-pick command ---"))
+	fmt.Fprintf(v, "%s\n", t.diff.theme.command.paint("git cherry-pick "+commit.Hash))
+}
+
+// prefetch loads and renders the patches of the commits around index into the cache,
+// until ctx is cancelled by the next cursor move
+func (t *TUI) prefetch(ctx context.Context, commits []Commit, index int) {
+	var neighbors [][]patchRequest
+	renderer := t.renderer()
+	for d := 1; d <= prefetchDistance; d++ {
+		for _, i := range []int{index + d, index - d} {
+			if i >= 0 && i < len(commits) {
+				neighbors = append(neighbors, t.detailRequests(commits[i]))
+			}
+		}
+	}
+	go func() {
+		for _, requests := range neighbors {
+			if t.preparePatches(ctx, renderer, requests); ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+}
+
+// cancelDetail stops loading the patch shown and its neighbors
+func (t *TUI) cancelDetail() {
+	if t.detailCancel != nil {
+		t.detailCancel()
+		t.detailCancel = nil
+	}
+}
+
+func getCommitFullPatchContext(ctx context.Context, hash string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "show", "--no-color", "--stat", "--patch", hash)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
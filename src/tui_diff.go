@@ -0,0 +1,348 @@
+package gittools
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// diffRenderer colors the uncolored output of git show for the TUI
+type diffRenderer struct {
+	theme    theme
+	wordDiff bool // reverse the words that differ between removed and added lines
+	syntax   bool // color code by the language of its file
+}
+
+var (
+	// statGraph matches a --stat line of a text file, capturing its +/- graph
+	statGraph = regexp.MustCompile(`^ .* \| +\d+ ([+-]*)$`)
+	// wordToken splits changed lines into the words compared by the word diff
+	wordToken = regexp.MustCompile(`\w+|\s+|[^\w\s]`)
+)
+
+// maxWordDiffTokens bounds the words of a line compared by the word diff
+const maxWordDiffTokens = 200
+
+// render returns the patch with escape sequences gocui understands
+func (r diffRenderer) render(patch string) string {
+	var b strings.Builder
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	var lang *language
+	inDiff, inHunk, prefix := false, false, 1
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git ") || strings.HasPrefix(line, "diff --cc "):
+			inDiff, inHunk = true, false
+			lang = languageFor(line[strings.LastIndex(line, " ")+1:])
+			b.WriteString(r.theme.meta.paint(line))
+		case inDiff && strings.HasPrefix(line, "@@"):
+			inHunk = true
+			prefix = strings.IndexFunc(line, func(c rune) bool { return c != '@' }) - 1
+			b.WriteString(r.hunkHeader(line, prefix))
+		case inHunk && isChangedLine(line, prefix):
+			// Removed lines followed by as many added lines are compared word by word
+			end := i
+			for end < len(lines) && isChangedLine(lines[end], prefix) {
+				end++
+			}
+			r.writeChanges(&b, lines[i:end], prefix, lang)
+			i = end - 1
+			continue
+		case inHunk && strings.HasPrefix(line, "\\"):
+			b.WriteString(line) // "\ No newline at end of file"
+		case inHunk:
+			b.WriteString(r.code(line, prefix, style{}, lang, nil))
+		case inDiff:
+			b.WriteString(r.theme.meta.paint(line))
+		case strings.HasPrefix(line, "commit "):
+			b.WriteString(r.theme.commit.paint(line))
+		case statGraph.MatchString(line):
+			graph := statGraph.FindStringSubmatchIndex(line)[2]
+			b.WriteString(line[:graph])
+			b.WriteString(strings.NewReplacer("+", r.theme.added.paint("+"), "-", r.theme.removed.paint("-")).Replace(line[graph:]))
+		default:
+			b.WriteString(line)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// isChangedLine reports whether a hunk line is added or removed; combined diffs have
+// a column per parent
+func isChangedLine(line string, prefix int) bool {
+	return len(line) >= prefix && strings.ContainsAny(line[:prefix], "+-")
+}
+
+// hunkHeader colors the @@ range of a hunk header, leaving the function name after it
+func (r diffRenderer) hunkHeader(line string, prefix int) string {
+	marker := strings.Repeat("@", prefix+1)
+	end := strings.Index(line[len(marker):], marker)
+	if end < 0 {
+		return r.theme.hunk.paint(line)
+	}
+	end += 2 * len(marker)
+	return r.theme.hunk.paint(line[:end]) + line[end:]
+}
+
+// writeChanges writes a block of changed lines, pairing each run of removed lines
+// with the added lines after it for the word diff
+func (r diffRenderer) writeChanges(b *strings.Builder, lines []string, prefix int, lang *language) {
+	for start := 0; start < len(lines); {
+		removed := start
+		for removed < len(lines) && strings.Contains(lines[removed][:prefix], "-") {
+			removed++
+		}
+		added := removed
+		for added < len(lines) && !strings.Contains(lines[added][:prefix], "-") {
+			added++
+		}
+		paired := r.wordDiff && removed-start == added-removed
+		for i := start; i < removed; i++ {
+			var changed [][]int
+			if paired {
+				changed, _ = changedWords(lines[i][prefix:], lines[i+removed-start][prefix:])
+			}
+			b.WriteString(r.code(lines[i], prefix, r.theme.removed, lang, changed))
+			b.WriteString("\n")
+		}
+		for i := removed; i < added; i++ {
+			var changed [][]int
+			if paired {
+				_, changed = changedWords(lines[i-removed+start][prefix:], lines[i][prefix:])
+			}
+			b.WriteString(r.code(lines[i], prefix, r.theme.added, lang, changed))
+			b.WriteString("\n")
+		}
+		start = added
+	}
+}
+
+// changedWords returns the byte ranges of the words only in old and only in new. It
+// returns nothing when the lines have too little in common for the highlight to help.
+func changedWords(old, new string) ([][]int, [][]int) {
+	a, b := wordToken.FindAllStringIndex(old, -1), wordToken.FindAllStringIndex(new, -1)
+	if len(a) > maxWordDiffTokens || len(b) > maxWordDiffTokens {
+		return nil, nil
+	}
+	var onlyOld, onlyNew [][]int
+	common := 0
+	for _, op := range lcsDiff(len(a), len(b), func(i, j int) bool {
+		return old[a[i][0]:a[i][1]] == new[b[j][0]:b[j][1]]
+	}) {
+		switch op.kind {
+		case '=':
+			common++
+		case '-':
+			onlyOld = append(onlyOld, a[op.i])
+		case '+':
+			onlyNew = append(onlyNew, b[op.j])
+		}
+	}
+	if common*2 < max(len(a), len(b)) {
+		return nil, nil
+	}
+	return onlyOld, onlyNew
+}
+
+// code draws a context or changed line: the prefix and code in base, with syntax
+// coloring and the changed byte ranges (of the code, after the prefix) reversed.
+// Context lines take the syntax colors; changed lines keep their color and show
+// keywords in bold.
+func (r diffRenderer) code(line string, prefix int, base style, lang *language, changed [][]int) string {
+	if len(line) < prefix {
+		return line
+	}
+	styles := make([]style, len(line))
+	for i := range styles {
+		styles[i] = base
+	}
+	code := styles[prefix:]
+	if r.syntax && lang != nil {
+		for _, span := range lang.spans(line[prefix:]) {
+			s := base
+			switch {
+			case base != style{}:
+				if span.kind != syntaxKeyword {
+					continue
+				}
+				s.fg |= gocui.AttrBold
+			case span.kind == syntaxKeyword:
+				s = r.theme.keyword
+			case span.kind == syntaxString:
+				s = r.theme.str
+			case span.kind == syntaxComment:
+				s = r.theme.comment
+			case span.kind == syntaxNumber:
+				s = r.theme.number
+			}
+			for i := span.start; i < span.end; i++ {
+				code[i] = s
+			}
+		}
+	}
+	for _, span := range changed {
+		for i := span[0]; i < span[1]; i++ {
+			code[i].fg |= gocui.AttrReverse
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if i == 0 || styles[i] != styles[i-1] {
+			b.WriteString(styles[i].sgr())
+		}
+		b.WriteByte(line[i])
+	}
+	b.WriteString(ColorReset)
+	return b.String()
+}
+
+// Kinds of syntax spans
+const (
+	syntaxKeyword = iota
+	syntaxString
+	syntaxComment
+	syntaxNumber
+)
+
+type syntaxSpan struct {
+	start, end, kind int
+}
+
+// language describes enough of a programming language's syntax to color a line
+type language struct {
+	keywords     map[string]bool
+	lineComment  string // e.g. "//" or "#"
+	blockComment bool   // C-style /* */ comments
+	quotes       string // characters delimiting strings
+}
+
+func keywords(list string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(list) {
+		words[word] = true
+	}
+	return words
+}
+
+var (
+	goLanguage = &language{
+		keywords: keywords(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var nil true false`),
+		lineComment: "//", blockComment: true, quotes: "\"'`",
+	}
+	cLanguage = &language{
+		keywords: keywords(`auto bool break case catch char class const continue default delete do double else
+			enum extern false final float for goto if import int long namespace new null nullptr package private
+			protected public return short signed sizeof static struct switch template this throw true try
+			typedef union unsigned using virtual void volatile while`),
+		lineComment: "//", blockComment: true, quotes: `"'`,
+	}
+	jsLanguage = &language{
+		keywords: keywords(`async await break case catch class const continue default delete do else export
+			extends false finally for from function if import in instanceof interface let new null of return
+			static super switch this throw true try type typeof undefined var void while yield`),
+		lineComment: "//", blockComment: true, quotes: "\"'`",
+	}
+	rustLanguage = &language{
+		keywords: keywords(`as async await break const continue crate else enum extern false fn for if impl in
+			let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use
+			where while`),
+		lineComment: "//", blockComment: true, quotes: `"`,
+	}
+	pythonLanguage = &language{
+		keywords: keywords(`and as assert async await break class continue def del elif else except False
+			finally for from global if import in is lambda None nonlocal not or pass raise return True try while
+			with yield`),
+		lineComment: "#", quotes: `"'`,
+	}
+	shellLanguage = &language{
+		keywords:    keywords(`case do done elif else esac export fi for function if in local return then until while`),
+		lineComment: "#", quotes: `"'`,
+	}
+	configLanguage = &language{lineComment: "#", quotes: `"'`}
+)
+
+// languages maps file extensions to their syntax
+var languages = map[string]*language{
+	".go": goLanguage,
+	".c":  cLanguage, ".h": cLanguage, ".cc": cLanguage, ".cpp": cLanguage, ".hpp": cLanguage,
+	".java": cLanguage, ".cs": cLanguage,
+	".js": jsLanguage, ".jsx": jsLanguage, ".mjs": jsLanguage, ".ts": jsLanguage, ".tsx": jsLanguage,
+	".rs": rustLanguage,
+	".py": pythonLanguage,
+	".sh": shellLanguage, ".bash": shellLanguage, ".zsh": shellLanguage,
+	".yml": configLanguage, ".yaml": configLanguage, ".toml": configLanguage,
+}
+
+// languageFor returns the syntax of a file, or nil if it is not known
+func languageFor(path string) *language {
+	return languages[strings.ToLower(filepath.Ext(path))]
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// spans returns the keywords, strings, comments and numbers in a line of code. Block
+// comments are only recognized within the line, or by a leading "*" on the lines
+// inside them.
+func (l *language) spans(code string) []syntaxSpan {
+	if trimmed := strings.TrimSpace(code); l.blockComment && strings.HasPrefix(trimmed, "*") {
+		return []syntaxSpan{{0, len(code), syntaxComment}}
+	}
+	var spans []syntaxSpan
+	for i := 0; i < len(code); {
+		c := code[i]
+		end := i + 1
+		switch {
+		case l.lineComment != "" && strings.HasPrefix(code[i:], l.lineComment) &&
+			(l.lineComment != "#" || i == 0 || code[i-1] == ' ' || code[i-1] == '\t'):
+			return append(spans, syntaxSpan{i, len(code), syntaxComment})
+		case l.blockComment && strings.HasPrefix(code[i:], "/*"):
+			end = len(code)
+			if close := strings.Index(code[i+2:], "*/"); close >= 0 {
+				end = i + 2 + close + 2
+			}
+			spans = append(spans, syntaxSpan{i, end, syntaxComment})
+		case strings.IndexByte(l.quotes, c) >= 0:
+			for end < len(code) && code[end] != c {
+				if code[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(code))
+			spans = append(spans, syntaxSpan{i, end, syntaxString})
+		case c >= '0' && c <= '9':
+			for end < len(code) && (isWordByte(code[end]) || code[end] == '.') {
+				end++
+			}
+			spans = append(spans, syntaxSpan{i, end, syntaxNumber})
+		case isWordByte(c):
+			for end < len(code) && isWordByte(code[end]) {
+				end++
+			}
+			if l.keywords[code[i:end]] {
+				spans = append(spans, syntaxSpan{i, end, syntaxKeyword})
+			}
+		}
+		i = end
+	}
+	return spans
+}
+
+func (t *TUI) toggleWordDiff(g *gocui.Gui, v *gocui.View) error {
+	t.diff.wordDiff = !t.diff.wordDiff
+	if t.diff.wordDiff {
+		t.setStatus("Word diff on")
+	} else {
+		t.setStatus("Word diff off")
+	}
+	t.rerenderPatch()
+	return nil
+}
@@ -0,0 +1,191 @@
+package gittools
+
+import (
+	"fmt"
+
+	"github.com/jroimartin/gocui"
+)
+
+// toggleDual adds a list of the commits only on branch2 below the commit list, which
+// then shows the missing and ported commits together, like git log --left-right
+// --cherry-mark. The second list needs the comparison the other way round, so it
+// is loaded in the background.
+func (t *TUI) toggleDual(g *gocui.Gui, v *gocui.View) error {
+	if !t.dual {
+		t.reload(t.branch1, t.branch2, false, true)
+		return nil
+	}
+	t.dual, t.onOther = false, false
+	t.others, t.otherEquivalents, t.partners = nil, nil, nil
+	t.all = t.missing
+	if t.showPorted {
+		t.all = t.ported
+	}
+	if _, err := g.SetCurrentView("list"); err != nil {
+		return err
+	}
+	return t.applyFilter(t.filter)
+}
+
+// setOthers lists the commits of the comparison of branch2 with branch1 and links
+// the commits of both lists that are equivalent
+func (t *TUI) setOthers(forward, reverse *Comparison) {
+	t.others = reverse.Commits
+	t.otherEquivalents = make(map[string]Equivalent)
+	t.partners = make(map[string]string)
+	listed := make(map[string]bool)
+	for _, commit := range forward.Commits {
+		listed[commit.Hash] = true
+	}
+	for _, commit := range reverse.Commits {
+		listed[commit.Hash] = true
+	}
+	link := func(ported []PortedCommit) {
+		for _, p := range ported {
+			if listed[p.Equivalent.Hash] && t.partners[p.Hash] == "" && t.partners[p.Equivalent.Hash] == "" {
+				t.partners[p.Hash] = p.Equivalent.Hash
+				t.partners[p.Equivalent.Hash] = p.Hash
+			}
+		}
+	}
+	link(forward.Ported)
+	link(reverse.Ported)
+	for _, p := range reverse.Ported {
+		t.otherEquivalents[p.Hash] = p.Equivalent
+	}
+	t.otherCursor = min(t.otherCursor, max(0, len(t.others)-1))
+}
+
+// layoutOthers draws the branch2 list in dual mode and titles the commit list
+func (t *TUI) layoutOthers(g *gocui.Gui, width, top, bottom int) error {
+	list, err := g.View("list")
+	if err != nil {
+		return err
+	}
+	if !t.dual {
+		list.Title = "Commits"
+		g.DeleteView("others")
+		return nil
+	}
+	list.Title = fmt.Sprintf("Only on %s", t.branch1)
+	v, err := g.SetView("others", 0, top, width, bottom)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Highlight = true
+		v.SelBgColor = t.theme.selection.bg
+		v.SelFgColor = t.theme.selection.fg
+		t.updateOtherList(v)
+		t.linkPartner()
+	}
+	v.Title = fmt.Sprintf("Only on %s", t.branch2)
+	return nil
+}
+
+func (t *TUI) updateOtherList(v *gocui.View) {
+	v.Clear()
+	h := t.highlighter()
+	for _, commit := range t.others {
+		marker := " "
+		if t.partners[commit.Hash] != "" {
+			marker = "="
+		}
+		fmt.Fprintf(v, " %s%s %s (%s, %s)\n", marker, commit.Hash[:8],
+			h.highlight(commit.Subject, ""), h.highlight(commit.Author, ""), commit.Date)
+	}
+	t.setCursor(v, t.otherCursor)
+}
+
+func (t *TUI) otherUp(g *gocui.Gui, v *gocui.View) error {
+	if t.otherCursor > 0 {
+		t.selectOther(t.otherCursor - 1)
+	}
+	return nil
+}
+
+func (t *TUI) otherDown(g *gocui.Gui, v *gocui.View) error {
+	if t.otherCursor < len(t.others)-1 {
+		t.selectOther(t.otherCursor + 1)
+	}
+	return nil
+}
+
+// selectOther moves the cursor of the branch2 list to index and shows its commit
+func (t *TUI) selectOther(index int) {
+	t.otherCursor = index
+	if v, err := t.gui.View("others"); err == nil {
+		t.setCursor(v, index)
+	}
+	if v, err := t.gui.View("detail"); err == nil {
+		t.updateOtherDetail(v, index)
+	}
+	t.renderStatus()
+}
+
+// focusList returns from the branch2 list to the commit list and its commit
+func (t *TUI) focusList(g *gocui.Gui, v *gocui.View) error {
+	if _, err := g.SetCurrentView("list"); err != nil {
+		return err
+	}
+	t.selectCommit(t.current)
+	t.renderStatus()
+	return nil
+}
+
+// linkPartner moves the cursor of the list not shown in the patch pane to the
+// equivalent of the commit shown, or hides it when the commit has none
+func (t *TUI) linkPartner() {
+	list, err := t.gui.View("list")
+	if err != nil {
+		return
+	}
+	others, err := t.gui.View("others")
+	if err != nil {
+		list.Highlight = true
+		return
+	}
+	if t.onOther {
+		others.Highlight = true
+		i := -1
+		if t.otherCursor < len(t.others) {
+			i = commitIndex(t.commits, t.partners[t.others[t.otherCursor].Hash])
+		}
+		list.Highlight = i >= 0
+		if i >= 0 {
+			t.current = i
+			t.setCursor(list, i)
+		}
+		return
+	}
+	list.Highlight = true
+	i := -1
+	if t.current < len(t.commits) {
+		i = commitIndex(t.others, t.partners[t.commits[t.current].Hash])
+	}
+	others.Highlight = i >= 0
+	if i >= 0 {
+		t.otherCursor = i
+		t.setCursor(others, i)
+	}
+}
+
+// shownCommit returns the commit the patch pane shows
+func (t *TUI) shownCommit() (Commit, bool) {
+	commits, index := t.commits, t.current
+	if t.onOther {
+		commits, index = t.others, t.otherCursor
+	}
+	if index >= len(commits) {
+		return Commit{}, false
+	}
+	return commits[index], true
+}
+
+// otherClassification says how a commit of the branch2 list relates to branch1
+func (t *TUI) otherClassification(commit Commit) string {
+	if equivalent, ok := t.otherEquivalents[commit.Hash]; ok {
+		return fmt.Sprintf("only on %s; %s match %s", t.branch2, equivalent.Strategy, equivalent.Hash[:8])
+	}
+	return "only on " + t.branch2
+}
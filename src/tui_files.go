@@ -0,0 +1,228 @@
+package gittools
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// commitFile is a file changed by the commit in the detail pane
+type commitFile struct {
+	path    string
+	oldPath string // set for renames and copies
+	added   int
+	deleted int
+	binary  bool
+}
+
+// parseNumstat reads the output of git show --numstat -z. Renamed files have an
+// empty path followed by the old and new path.
+func parseNumstat(output string) []commitFile {
+	tokens := strings.Split(output, "\x00")
+	var files []commitFile
+	for i := 0; i < len(tokens); i++ {
+		fields := strings.SplitN(strings.TrimLeft(tokens[i], "\n"), "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		file := commitFile{path: fields[2], binary: fields[0] == "-"}
+		file.added, _ = strconv.Atoi(fields[0])
+		file.deleted, _ = strconv.Atoi(fields[1])
+		if file.path == "" && i+2 < len(tokens) {
+			file.oldPath, file.path = tokens[i+1], tokens[i+2]
+			i += 2
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// writePatch prints a colored patch without the diffs of collapsed files, recording
+// the lines where file diffs and hunks start; line is the first line written
+func (t *TUI) writePatch(w io.Writer, line int, patch string) {
+	file, skipped := -1, 0
+	flush := func() {
+		if skipped > 0 {
+			fmt.Fprintln(w, t.theme.hunk.paint(fmt.Sprintf("    ... %d line(s) collapsed", skipped)))
+			line++
+			skipped = 0
+		}
+	}
+	for _, text := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		plain := sgrSequence.ReplaceAllString(text, "")
+		switch {
+		case strings.HasPrefix(plain, "diff --git ") || strings.HasPrefix(plain, "diff --cc "):
+			flush()
+			file++
+			t.fileLines = append(t.fileLines, line)
+		case file >= 0 && t.collapsed[file]:
+			skipped++
+			continue
+		case strings.HasPrefix(plain, "@@"):
+			t.hunkLines = append(t.hunkLines, line)
+		}
+		fmt.Fprintln(w, text)
+		line++
+	}
+	flush()
+}
+
+func (t *TUI) setFiles(files []commitFile) {
+	t.files = files
+	if t.fileCursor >= len(files) {
+		t.fileCursor = 0
+	}
+	t.renderFiles()
+}
+
+// renderFiles lists the changed files with their line counts; renamed files are
+// marked R and binary files B, collapsed files ▸
+func (t *TUI) renderFiles() {
+	v, err := t.gui.View("files")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	v.Title = fmt.Sprintf("Files (%d)", len(t.files))
+	for i, file := range t.files {
+		fold := "▾"
+		if t.collapsed[i] {
+			fold = "▸"
+		}
+		marker, name := " ", file.path
+		if file.oldPath != "" {
+			marker, name = "R", file.oldPath+" → "+file.path
+		}
+		change := t.theme.added.paint(fmt.Sprintf("+%d", file.added)) + " " + t.theme.removed.paint(fmt.Sprintf("-%d", file.deleted))
+		if file.binary {
+			marker, change = "B", t.theme.note.paint("binary")
+		}
+		fmt.Fprintf(v, "%s %s %s %s\n", fold, marker, name, change)
+	}
+	t.setCursor(v, t.fileCursor)
+}
+
+func (t *TUI) fileUp(g *gocui.Gui, v *gocui.View) error {
+	if t.fileCursor > 0 {
+		t.selectFile(t.fileCursor - 1)
+	}
+	return nil
+}
+
+func (t *TUI) fileDown(g *gocui.Gui, v *gocui.View) error {
+	if t.fileCursor < len(t.files)-1 {
+		t.selectFile(t.fileCursor + 1)
+	}
+	return nil
+}
+
+// selectFile moves the file cursor and scrolls the patch to the file's diff
+func (t *TUI) selectFile(index int) {
+	t.fileCursor = index
+	if v, err := t.gui.View("files"); err == nil {
+		t.setCursor(v, index)
+	}
+	if index < len(t.fileLines) {
+		t.scrollDetailTo(t.fileLines[index])
+	}
+}
+
+func (t *TUI) scrollDetailTo(line int) {
+	if v, err := t.gui.View("detail"); err == nil {
+		ox, _ := v.Origin()
+		v.SetOrigin(ox, line)
+	}
+}
+
+// openFile shows the selected file's diff and focuses the patch
+func (t *TUI) openFile(g *gocui.Gui, v *gocui.View) error {
+	if len(t.files) == 0 {
+		return nil
+	}
+	if t.collapsed[t.fileCursor] {
+		t.toggleCollapse(g, v)
+	}
+	t.selectFile(t.fileCursor)
+	_, err := g.SetCurrentView("detail")
+	return err
+}
+
+func (t *TUI) toggleCollapse(g *gocui.Gui, v *gocui.View) error {
+	if len(t.files) == 0 {
+		return nil
+	}
+	t.collapsed[t.fileCursor] = !t.collapsed[t.fileCursor]
+	t.rerenderPatch()
+	return nil
+}
+
+// toggleCollapseAll collapses every file, or expands them all if all are collapsed
+func (t *TUI) toggleCollapseAll(g *gocui.Gui, v *gocui.View) error {
+	all := true
+	for i := range t.files {
+		all = all && t.collapsed[i]
+	}
+	for i := range t.files {
+		t.collapsed[i] = !all
+	}
+	t.rerenderPatch()
+	return nil
+}
+
+// rerenderPatch shows the loaded patch again after files were collapsed or expanded,
+// keeping the selected file in view
+func (t *TUI) rerenderPatch() {
+	v, err := t.gui.View("detail")
+	commit, ok := t.shownCommit()
+	if err != nil || !ok || t.compare != compareOff {
+		return
+	}
+	t.fileLines, t.hunkLines = nil, nil
+	t.renderCommitDetail(v, commit, t.detailPatch, nil)
+	t.renderFiles()
+	t.selectFile(t.fileCursor)
+}
+
+func (t *TUI) nextHunk(g *gocui.Gui, v *gocui.View) error {
+	return t.jumpToHunk(1)
+}
+
+func (t *TUI) previousHunk(g *gocui.Gui, v *gocui.View) error {
+	return t.jumpToHunk(-1)
+}
+
+// jumpToHunk scrolls the patch to the next or previous hunk and selects its file
+func (t *TUI) jumpToHunk(direction int) error {
+	detail, err := t.gui.View("detail")
+	if err != nil {
+		return nil
+	}
+	_, oy := detail.Origin()
+	target := -1
+	for _, line := range t.hunkLines {
+		if direction > 0 && line > oy {
+			target = line
+			break
+		}
+		if direction < 0 && line < oy {
+			target = line
+		}
+	}
+	if target < 0 {
+		t.setStatus("No more hunks")
+		return nil
+	}
+	t.scrollDetailTo(target)
+	for i, line := range t.fileLines {
+		if line <= target {
+			t.fileCursor = i
+		}
+	}
+	if v, err := t.gui.View("files"); err == nil {
+		t.setCursor(v, t.fileCursor)
+	}
+	return nil
+}
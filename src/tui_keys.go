@@ -0,0 +1,291 @@
+package gittools
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jroimartin/gocui"
+)
+
+// keyAction is a command of the find-missing TUI; keys.<name> in the configuration
+// replaces its default keys
+type keyAction struct {
+	name        string
+	description string
+	views       []string // panes the keys work in
+	keys        []string // default keys
+	handler     func(*TUI, *gocui.Gui, *gocui.View) error
+}
+
+var (
+	allPanes   = []string{"list", "others", "files", "detail"}
+	listPane   = []string{"list"}
+	othersPane = []string{"others"}
+	filesPane  = []string{"files"}
+	patchPane  = []string{"detail"}
+)
+
+// keyActions is the keymap of the find-missing TUI, in the order the help lists it
+var keyActions []keyAction
+
+// The keymap is filled in by init because showHelp lists it
+func init() {
+	keyActions = []keyAction{
+		{"quit", "Quit", allPanes, []string{"q"}, (*TUI).quit},
+		{"help", "Show this help", allPanes, []string{"?"}, (*TUI).showHelp},
+		{"command", "Run a command: cherry-pick, export, filter, goto, ...", allPanes, []string{":"}, (*TUI).startCommand},
+		{"next-pane", "Move to the next pane", allPanes, []string{"tab"}, (*TUI).nextPane},
+		{"page-up", "Scroll the patch up a page", allPanes, []string{"pgup"}, (*TUI).pageUpPatch},
+		{"page-down", "Scroll the patch down a page", allPanes, []string{"pgdn"}, (*TUI).pageDownPatch},
+		{"previous-hunk", "Scroll the patch to the previous hunk", allPanes, []string{"["}, (*TUI).previousHunk},
+		{"next-hunk", "Scroll the patch to the next hunk", allPanes, []string{"]"}, (*TUI).nextHunk},
+		{"compare", "Cycle through comparisons with the equivalent commit", allPanes, []string{"="}, (*TUI).toggleCompare},
+		{"word-diff", "Turn the word diff on or off", allPanes, []string{"w"}, (*TUI).toggleWordDiff},
+		{"branch1", "Pick the branch to port from", allPanes, []string{"b"}, (*TUI).pickBranch1},
+		{"branch2", "Pick the branch to port to", allPanes, []string{"B"}, (*TUI).pickBranch2},
+		{"swap", "Swap the branches", allPanes, []string{"s"}, (*TUI).swapBranches},
+		{"refresh", "Fetch and compare the branches again", allPanes, []string{"r"}, (*TUI).refresh},
+		{"dual", "Also list the commits only on branch2", allPanes, []string{"d"}, (*TUI).toggleDual},
+
+		{"up", "Select the previous commit", listPane, []string{"up", "k"}, (*TUI).cursorUp},
+		{"down", "Select the next commit", listPane, []string{"down", "j"}, (*TUI).cursorDown},
+		{"scroll-left", "Scroll the list left", listPane, []string{"left", "h"}, (*TUI).scrollListLeft},
+		{"scroll-right", "Scroll the list right", listPane, []string{"right", "l"}, (*TUI).scrollListRight},
+		{"focus-patch", "Move to the patch", listPane, []string{"enter"}, (*TUI).showCommit},
+		{"search", "Search subjects, authors and hashes", listPane, []string{"/"}, (*TUI).startSearch},
+		{"next-match", "Select the next search match", listPane, []string{"n"}, (*TUI).nextMatch},
+		{"previous-match", "Select the previous search match", listPane, []string{"N"}, (*TUI).previousMatch},
+		{"filter", "Filter the list", listPane, []string{"f"}, (*TUI).startFilter},
+		{"toggle-ported", "Switch between missing and ported commits", listPane, []string{"p"}, (*TUI).togglePorted},
+		{"mark", "Mark or unmark the commit", listPane, []string{"space", "m"}, (*TUI).toggleMark},
+		{"mark-all", "Mark or unmark all listed commits", listPane, []string{"a"}, (*TUI).markAll},
+		{"visual", "Start a range; again to mark it", listPane, []string{"v"}, (*TUI).toggleVisual},
+		{"cancel-visual", "Cancel the range", listPane, []string{"esc"}, (*TUI).cancelVisual},
+		{"cherry-pick", "Cherry-pick the marked commits onto branch2", listPane, []string{"c"}, (*TUI).confirmCherryPick},
+		{"export-command", "Print a cherry-pick command for the marked commits on exit", listPane, []string{"e"}, (*TUI).exportCommand},
+		{"export-patches", "Write the marked commits as patch files", listPane, []string{"E"}, (*TUI).exportPatches},
+		{"triage", "Set the triage status: skip, needs-backport, backported", listPane, []string{"t"}, (*TUI).startTriage},
+		{"note", "Write a triage note on the commit", listPane, []string{"T"}, (*TUI).startNote},
+
+		{"other-up", "Select the previous commit", othersPane, []string{"up", "k"}, (*TUI).otherUp},
+		{"other-down", "Select the next commit", othersPane, []string{"down", "j"}, (*TUI).otherDown},
+		{"other-patch", "Move to the patch", othersPane, []string{"enter"}, (*TUI).showCommit},
+		{"leave-other", "Return to the commit list", othersPane, []string{"esc"}, (*TUI).focusList},
+
+		{"file-up", "Select the previous file", filesPane, []string{"up", "k"}, (*TUI).fileUp},
+		{"file-down", "Select the next file", filesPane, []string{"down", "j"}, (*TUI).fileDown},
+		{"open-file", "Show the file's diff in the patch", filesPane, []string{"enter"}, (*TUI).openFile},
+		{"collapse", "Collapse or expand the file's diff", filesPane, []string{"space"}, (*TUI).toggleCollapse},
+		{"collapse-all", "Collapse or expand all diffs", filesPane, []string{"a"}, (*TUI).toggleCollapseAll},
+		{"leave-files", "Return to the commit list", filesPane, []string{"esc"}, (*TUI).backToList},
+
+		{"scroll-up", "Scroll the patch up", patchPane, []string{"up", "k"}, (*TUI).scrollDetailUp},
+		{"scroll-down", "Scroll the patch down", patchPane, []string{"down", "j"}, (*TUI).scrollDetailDown},
+		{"next-page", "Scroll the patch down a page", patchPane, []string{"space"}, (*TUI).pageDownPatch},
+		{"leave-patch", "Return to the commit list", patchPane, []string{"esc"}, (*TUI).backToList},
+	}
+}
+
+// paneNames are the help headings of the panes
+var paneNames = map[string]string{"list": "Commit list", "others": "Branch2 list (d)", "files": "File pane", "detail": "Patch pane"}
+
+var keyNames = map[string]gocui.Key{
+	"up": gocui.KeyArrowUp, "down": gocui.KeyArrowDown, "left": gocui.KeyArrowLeft, "right": gocui.KeyArrowRight,
+	"enter": gocui.KeyEnter, "esc": gocui.KeyEsc, "tab": gocui.KeyTab, "space": gocui.KeySpace,
+	"backspace": gocui.KeyBackspace2, "delete": gocui.KeyDelete, "insert": gocui.KeyInsert,
+	"home": gocui.KeyHome, "end": gocui.KeyEnd, "pgup": gocui.KeyPgup, "pgdn": gocui.KeyPgdn,
+	"f1": gocui.KeyF1, "f2": gocui.KeyF2, "f3": gocui.KeyF3, "f4": gocui.KeyF4, "f5": gocui.KeyF5,
+	"f6": gocui.KeyF6, "f7": gocui.KeyF7, "f8": gocui.KeyF8, "f9": gocui.KeyF9, "f10": gocui.KeyF10,
+	"f11": gocui.KeyF11, "f12": gocui.KeyF12,
+}
+
+// parseKey reads a key: a single character, a name such as "enter" or "pgdn", or
+// "ctrl+" and a letter
+func parseKey(spec string) (interface{}, error) {
+	name := strings.ToLower(spec)
+	if letter, ok := strings.CutPrefix(name, "ctrl+"); ok && len(letter) == 1 && letter[0] >= 'a' && letter[0] <= 'z' {
+		return gocui.KeyCtrlA + gocui.Key(letter[0]-'a'), nil
+	}
+	if key, ok := keyNames[name]; ok {
+		return key, nil
+	}
+	if spec == " " {
+		return gocui.KeySpace, nil
+	}
+	if r, size := utf8.DecodeRuneInString(spec); r != utf8.RuneError && size == len(spec) {
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown key '%s'", spec)
+}
+
+// keyLabel is how the help and the status bar show a key
+func keyLabel(spec string) string {
+	name := strings.ToLower(spec)
+	switch name {
+	case "up":
+		return "↑"
+	case "down":
+		return "↓"
+	case "left":
+		return "←"
+	case "right":
+		return "→"
+	case "pgup":
+		return "PgUp"
+	case "pgdn":
+		return "PgDn"
+	}
+	if letter, ok := strings.CutPrefix(name, "ctrl+"); ok {
+		return "Ctrl-" + strings.ToUpper(letter)
+	}
+	if len(spec) > 1 {
+		return strings.ToUpper(spec[:1]) + name[1:]
+	}
+	return spec
+}
+
+// loadKeymap reads the keys.<action> settings, returning the keys of every action
+func loadKeymap(cfg *Config) (map[string][]string, error) {
+	keymap := make(map[string][]string)
+	for _, action := range keyActions {
+		keymap[action.name] = action.keys
+	}
+	for _, key := range cfg.Keys() {
+		name, ok := strings.CutPrefix(key, "keys.")
+		if !ok {
+			continue
+		}
+		if _, known := keymap[name]; !known {
+			return nil, fmt.Errorf("unknown key action '%s' in %s (press ? in the TUI for the list)", name, key)
+		}
+		keymap[name] = cfg.List(key)
+	}
+	return keymap, checkKeymap(keymap)
+}
+
+// checkKeymap reports keys that do not parse or that two actions share in a pane
+func checkKeymap(keymap map[string][]string) error {
+	bound := make(map[string]string) // pane and key to action
+	for _, action := range keyActions {
+		for _, spec := range keymap[action.name] {
+			key, err := parseKey(spec)
+			if err != nil {
+				return fmt.Errorf("keys.%s: %v", action.name, err)
+			}
+			for _, view := range action.views {
+				id := fmt.Sprintf("%s %T %v", view, key, key)
+				if other, ok := bound[id]; ok && other != action.name {
+					return fmt.Errorf("keys.%s: '%s' is already bound to %s in the %s", action.name, spec, other, strings.ToLower(paneNames[view]))
+				}
+				bound[id] = action.name
+			}
+		}
+	}
+	return nil
+}
+
+// setActionKeybindings binds the keys of every action in the keymap
+func (t *TUI) setActionKeybindings() error {
+	for _, action := range keyActions {
+		handler := func(g *gocui.Gui, v *gocui.View) error {
+			return action.handler(t, g, v)
+		}
+		for _, spec := range t.keymap[action.name] {
+			key, err := parseKey(spec)
+			if err != nil {
+				return err
+			}
+			for _, view := range action.views {
+				if err := t.gui.SetKeybinding(view, key, gocui.ModNone, handler); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return t.setHelpKeybindings()
+}
+
+// keysOf returns the labels of an action's keys, joined with "/"
+func (t *TUI) keysOf(name string) string {
+	var labels []string
+	for _, spec := range t.keymap[name] {
+		labels = append(labels, keyLabel(spec))
+	}
+	return strings.Join(labels, "/")
+}
+
+// nextPane moves the focus from the list to the file pane, the patch and back
+func (t *TUI) nextPane(g *gocui.Gui, v *gocui.View) error {
+	next := map[string]string{"list": "files", "others": "files", "files": "detail", "detail": "list"}[v.Name()]
+	if v.Name() == "list" && t.dual {
+		next = "others"
+		t.selectOther(t.otherCursor)
+	}
+	if next == "" {
+		next = "list"
+	}
+	_, err := g.SetCurrentView(next)
+	return err
+}
+
+// helpText lists the bindings of the keymap by pane
+func (t *TUI) helpText() string {
+	var b strings.Builder
+	section := ""
+	for _, action := range keyActions {
+		heading := "All panes"
+		if len(action.views) == 1 {
+			heading = paneNames[action.views[0]]
+		}
+		if heading != section {
+			if section != "" {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "%s\n", t.theme.heading.paint(heading))
+			section = heading
+		}
+		keys := t.keysOf(action.name)
+		if keys == "" {
+			keys = "(none)"
+		}
+		fmt.Fprintf(&b, "  %-14s %-16s %s\n", keys, action.name, action.description)
+	}
+	fmt.Fprintf(&b, "\n%s\n", t.theme.heading.paint("Commands"))
+	for _, command := range paletteCommands {
+		fmt.Fprintf(&b, "  :%-30s %s\n", strings.TrimSpace(command.name+" "+command.args), command.description)
+	}
+	b.WriteString("\nCtrl-C always quits. Rebind with keys.<action> in the configuration, e.g. keys.down = ctrl+n,j")
+	return b.String()
+}
+
+func (t *TUI) showHelp(g *gocui.Gui, v *gocui.View) error {
+	t.showPopup("help", "Keys (j/k: scroll, Esc/q/?: close)", t.helpText())
+	return nil
+}
+
+// setHelpKeybindings binds the keys scrolling and closing the help
+func (t *TUI) setHelpKeybindings() error {
+	scroll := func(lines int) func(*gocui.Gui, *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error {
+			_, oy := v.Origin()
+			_, height := v.Size()
+			total := strings.Count(t.popup.text, "\n") + 1
+			return v.SetOrigin(0, max(0, min(oy+lines, total-height)))
+		}
+	}
+	bindings := []struct {
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}{
+		{'j', scroll(1)}, {gocui.KeyArrowDown, scroll(1)}, {gocui.KeyPgdn, scroll(10)}, {gocui.KeySpace, scroll(10)},
+		{'k', scroll(-1)}, {gocui.KeyArrowUp, scroll(-1)}, {gocui.KeyPgup, scroll(-10)},
+		{gocui.KeyEsc, t.closePopup}, {'q', t.closePopup}, {'?', t.closePopup},
+	}
+	for _, b := range bindings {
+		if err := t.gui.SetKeybinding("help", b.key, gocui.ModNone, b.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package gittools
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// paletteCommands are the commands of the : prompt
+var paletteCommands = []struct {
+	name, args, description string
+}{
+	{"cherry-pick", "", "Cherry-pick the marked commits onto branch2"},
+	{"export", "[patches]", "Print a cherry-pick command on exit, or write patch files"},
+	{"filter", "[terms]", "Filter the list (no terms: show all commits)"},
+	{"goto", "<n|hash|ref>", "Select the n-th commit or a commit by hash or ref"},
+	{"search", "<text>", "Search subjects, authors and hashes"},
+	{"triage", "<status> [commit]", "Triage the commit: skip, needs-backport, backported <commit>, none"},
+	{"note", "[text]", "Write the commit's triage note (no text: remove it)"},
+	{"branch1", "[branch]", "Compare another branch with branch2 (no branch: pick one)"},
+	{"branch2", "[branch]", "Compare branch1 with another branch (no branch: pick one)"},
+	{"swap", "", "Swap the branches"},
+	{"refresh", "", "Fetch and compare the branches again"},
+	{"help", "", "Show the keys and commands"},
+	{"quit", "", "Quit"},
+}
+
+// parseCommand splits a command line into a known command and its argument
+func parseCommand(line string) (string, string, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "cherry-pick", "swap", "refresh", "help", "quit", "q":
+		if arg != "" {
+			return "", "", fmt.Errorf("%s takes no argument", name)
+		}
+	case "export":
+		if arg != "" && arg != "patches" {
+			return "", "", fmt.Errorf("expected 'export' or 'export patches'")
+		}
+	case "goto", "search":
+		if arg == "" {
+			return "", "", fmt.Errorf("%s needs an argument", name)
+		}
+	case "triage":
+		if _, _, err := parseTriageStatus(arg); err != nil || arg == "" {
+			return "", "", fmt.Errorf("expected 'triage <status> [commit]' (%s or none)", strings.Join(triageStatuses, ", "))
+		}
+	case "note":
+	case "branch1", "branch2":
+		if strings.Contains(arg, " ") {
+			return "", "", fmt.Errorf("expected a single branch")
+		}
+	case "filter":
+		if _, err := parseCommitFilter(arg); err != nil {
+			return "", "", err
+		}
+	default:
+		var names []string
+		for _, command := range paletteCommands {
+			names = append(names, command.name)
+		}
+		return "", "", fmt.Errorf("unknown command '%s' (%s)", name, strings.Join(names, ", "))
+	}
+	return name, arg, nil
+}
+
+// startCommand opens the command palette; the command runs on Enter
+func (t *TUI) startCommand(g *gocui.Gui, v *gocui.View) error {
+	var line string
+	t.openPrompt(&prompt{
+		label: ":",
+		changed: func(text string) error {
+			line = text
+			_, _, err := parseCommand(text)
+			return err
+		},
+		cancel: func() {},
+		done: func() {
+			if err := t.runCommand(line); err != nil {
+				t.setStatus("Error: %v", err)
+			}
+		},
+	})
+	return nil
+}
+
+// runCommand runs a command line of the palette
+func (t *TUI) runCommand(line string) error {
+	name, arg, err := parseCommand(line)
+	if err != nil {
+		return err
+	}
+	list, err := t.gui.View("list")
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "cherry-pick":
+		return t.confirmCherryPick(t.gui, list)
+	case "export":
+		if arg == "patches" {
+			return t.exportPatches(t.gui, list)
+		}
+		return t.exportCommand(t.gui, list)
+	case "filter":
+		if err := t.applyFilter(arg); err != nil {
+			return err
+		}
+		t.setStatus("Showing %d of %d commits", len(t.commits), len(t.all))
+	case "goto":
+		return t.gotoCommit(arg)
+	case "search":
+		t.search = arg
+		return t.jumpToMatch(1)
+	case "triage", "note":
+		if t.current >= len(t.commits) {
+			return fmt.Errorf("no commit selected")
+		}
+		if name == "note" {
+			return t.setTriageNote(t.commits[t.current].Hash, arg)
+		}
+		return t.setTriageStatus(t.commits[t.current].Hash, arg)
+	case "branch1", "branch2":
+		side := 1
+		if name == "branch2" {
+			side = 2
+		}
+		if arg == "" {
+			return t.startBranchPicker(side)
+		}
+		if !BranchExists(arg) {
+			return fmt.Errorf("branch '%s' does not exist", arg)
+		}
+		t.switchBranch(side, arg)
+	case "swap":
+		return t.swapBranches(t.gui, list)
+	case "refresh":
+		return t.refresh(t.gui, list)
+	case "help":
+		return t.showHelp(t.gui, list)
+	case "quit", "q":
+		// Quitting ends the main loop, which only an event handler can do
+		t.gui.Update(func(g *gocui.Gui) error {
+			return t.quit(g, list)
+		})
+	}
+	return nil
+}
+
+// gotoCommit selects the n-th listed commit, or the missing or ported commit a hash
+// prefix or ref names, clearing the filter or switching lists if needed
+func (t *TUI) gotoCommit(arg string) error {
+	if n, err := strconv.Atoi(arg); err == nil && len(arg) < 4 {
+		if n < 1 || n > len(t.commits) {
+			return fmt.Errorf("no commit %d; %d commit(s) are listed", n, len(t.commits))
+		}
+		t.selectCommit(n - 1)
+		return nil
+	}
+
+	hash := ""
+	for _, commit := range append(append([]Commit{}, t.missing...), t.ported...) {
+		if strings.HasPrefix(commit.Hash, strings.ToLower(arg)) {
+			hash = commit.Hash
+			break
+		}
+	}
+	if hash == "" {
+		output, err := exec.Command("git", "rev-parse", "--verify", "--quiet", arg+"^{commit}").Output()
+		if err != nil {
+			return fmt.Errorf("'%s' is not a commit", arg)
+		}
+		hash = strings.TrimSpace(string(output))
+	}
+
+	if commitIndex(t.all, hash) < 0 {
+		other := t.ported
+		if t.showPorted {
+			other = t.missing
+		}
+		if commitIndex(other, hash) < 0 {
+			return fmt.Errorf("%s is neither missing from %s nor ported", hash[:8], t.branch2)
+		}
+		t.togglePorted(t.gui, nil)
+	}
+	if commitIndex(t.commits, hash) < 0 {
+		if err := t.applyFilter(""); err != nil {
+			return err
+		}
+		t.setStatus("Filter cleared to show %s", hash[:8])
+	}
+	t.selectCommit(commitIndex(t.commits, hash))
+	return nil
+}
+
+// commitIndex returns the index of the commit with the hash, or -1
+func commitIndex(commits []Commit, hash string) int {
+	for i, commit := range commits {
+		if commit.Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
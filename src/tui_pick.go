@@ -0,0 +1,442 @@
+package gittools
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// pickRun is a cherry-pick of marked commits started from the TUI
+type pickRun struct {
+	queue   []Commit // commits to apply, oldest first
+	pos     int      // index of the commit being applied
+	skipped int      // commits skipped after failing to apply
+	running bool     // a git command is running; false while stopped on a conflict
+	output  string   // output of the failed git command
+}
+
+// popup is a dialog drawn over the panes
+type popup struct {
+	name  string // view name, which selects the keybindings
+	title string
+	text  string
+}
+
+// popupViews are the names of the dialogs, so stale ones can be removed
+var popupViews = []string{"confirm", "progress", "conflict", "help"}
+
+// setMarkKeybindings binds the keys of the cherry-pick dialogs
+func (t *TUI) setMarkKeybindings() error {
+	bindings := []struct {
+		view    string
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}{
+		{"confirm", 'y', t.startCherryPick},
+		{"confirm", gocui.KeyEnter, t.startCherryPick},
+		{"confirm", 'n', t.closePopup},
+		{"confirm", gocui.KeyEsc, t.closePopup},
+		{"conflict", 'c', t.continueCherryPick},
+		{"conflict", 's', t.skipCherryPick},
+		{"conflict", 'A', t.abortCherryPick},
+		{"conflict", gocui.KeyEsc, t.leaveConflict},
+	}
+	for _, b := range bindings {
+		if err := t.gui.SetKeybinding(b.view, b.key, gocui.ModNone, b.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layoutPopups draws the open dialog in the middle of the screen and removes closed ones
+func (t *TUI) layoutPopups(g *gocui.Gui) error {
+	for _, name := range popupViews {
+		if t.popup == nil || t.popup.name != name {
+			g.DeleteView(name)
+		}
+	}
+	if t.popup == nil {
+		return nil
+	}
+
+	maxX, maxY := g.Size()
+	width := min(maxX-4, 90)
+	lines := strings.Count(t.popup.text, "\n") + 1
+	height := min(maxY-4, lines+1)
+	x0, y0 := (maxX-width)/2, (maxY-height)/2
+	v, err := g.SetView(t.popup.name, x0, y0, x0+width, y0+height)
+	if err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+	v.Title = t.popup.title
+	v.Wrap = true
+	v.Clear()
+	fmt.Fprint(v, t.popup.text)
+	if _, err := g.SetViewOnTop(t.popup.name); err != nil {
+		return err
+	}
+	_, err = g.SetCurrentView(t.popup.name)
+	return err
+}
+
+func (t *TUI) showPopup(name, title, text string) {
+	t.popup = &popup{name: name, title: title, text: text}
+}
+
+func (t *TUI) closePopup(g *gocui.Gui, v *gocui.View) error {
+	t.popup = nil
+	if err := t.layoutPopups(g); err != nil {
+		return err
+	}
+	_, err := g.SetCurrentView("list")
+	return err
+}
+
+// inVisualRange reports whether the commit at index is in the visual selection
+func (t *TUI) inVisualRange(index int) bool {
+	return t.visual >= 0 && index >= min(t.visual, t.current) && index <= max(t.visual, t.current)
+}
+
+func (t *TUI) toggleMark(g *gocui.Gui, v *gocui.View) error {
+	if len(t.commits) == 0 {
+		return nil
+	}
+	hash := t.commits[t.current].Hash
+	if t.marked[hash] {
+		delete(t.marked, hash)
+	} else if !t.applied[hash] {
+		t.marked[hash] = true
+	}
+	t.status = ""
+	t.refreshList()
+	return t.cursorDown(g, v)
+}
+
+// markAll marks every listed commit not applied yet, or clears their marks if all
+// are marked
+func (t *TUI) markAll(g *gocui.Gui, v *gocui.View) error {
+	all := true
+	for _, commit := range t.commits {
+		if !t.applied[commit.Hash] && !t.marked[commit.Hash] {
+			all = false
+			break
+		}
+	}
+	for _, commit := range t.commits {
+		if all {
+			delete(t.marked, commit.Hash)
+		} else if !t.applied[commit.Hash] {
+			t.marked[commit.Hash] = true
+		}
+	}
+	t.status = ""
+	t.refreshList()
+	return nil
+}
+
+// toggleVisual starts a range selection at the cursor, or marks the selected range
+func (t *TUI) toggleVisual(g *gocui.Gui, v *gocui.View) error {
+	if len(t.commits) == 0 || t.current >= len(t.commits) {
+		t.visual = -1
+		return nil
+	}
+	if t.visual < 0 {
+		t.visual = t.current
+	} else {
+		// The list may have shrunk since the range started
+		last := min(max(t.visual, t.current), len(t.commits)-1)
+		for i := min(t.visual, t.current); i <= last; i++ {
+			if hash := t.commits[i].Hash; !t.applied[hash] {
+				t.marked[hash] = true
+			}
+		}
+		t.visual = -1
+	}
+	t.status = ""
+	t.refreshList()
+	return nil
+}
+
+func (t *TUI) cancelVisual(g *gocui.Gui, v *gocui.View) error {
+	if t.visual >= 0 {
+		t.visual = -1
+		t.refreshList()
+	}
+	return nil
+}
+
+// markedCommits returns the marked commits in the order they apply, including those
+// hidden by the filter or listed with the other of missing and ported commits, or
+// the commit under the cursor if none are marked
+func (t *TUI) markedCommits() ([]Commit, error) {
+	var commits []Commit
+	for _, commit := range append(append([]Commit(nil), t.missing...), t.ported...) {
+		if t.marked[commit.Hash] {
+			commits = append(commits, commit)
+		}
+	}
+	if len(commits) == 0 && len(t.commits) > 0 && !t.applied[t.commits[t.current].Hash] {
+		commits = append(commits, t.commits[t.current])
+	}
+	if len(commits) < 2 {
+		return commits, nil
+	}
+
+	// Dates can disagree with ancestry after a rebase, so order topologically
+	output, err := exec.Command("git", "rev-list", "--topo-order", "--reverse", t.branch1, "--not", t.branch2).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to order commits: %v", err)
+	}
+	order := make(map[string]int)
+	for i, hash := range strings.Fields(string(output)) {
+		order[hash] = i
+	}
+	sort.SliceStable(commits, func(i, j int) bool {
+		a, aOK := order[commits[i].Hash]
+		b, bOK := order[commits[j].Hash]
+		return aOK && (!bOK || a < b)
+	})
+	return commits, nil
+}
+
+func (t *TUI) confirmCherryPick(g *gocui.Gui, v *gocui.View) error {
+	if t.pick != nil {
+		t.showConflict() // resume the stopped cherry-pick
+		return nil
+	}
+	commits, err := t.markedCommits()
+	if err != nil {
+		t.setStatus("Error: %v", err)
+		return nil
+	}
+	if len(commits) == 0 {
+		t.setStatus("No commits to cherry-pick")
+		return nil
+	}
+	if exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/heads/"+t.branch2).Run() != nil {
+		t.setStatus("Error: '%s' is not a local branch; cherry-picks need a branch to apply to", t.branch2)
+		return nil
+	}
+
+	if err := checkCleanWorktree(); err != nil {
+		t.setStatus("Error: %v", err)
+		return nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Cherry-pick %d commit(s) onto %s?\n\n", len(commits), t.branch2)
+	if head := currentBranch(); head != t.branch2 {
+		if head == "" {
+			head = "a detached HEAD"
+		}
+		fmt.Fprintf(&text, "HEAD will be switched from %s to %s first.\n\n", head, t.branch2)
+	}
+	for _, commit := range commits {
+		fmt.Fprintf(&text, "  %s %s\n", commit.Hash[:8], commit.Subject)
+	}
+	fmt.Fprint(&text, "\ny/Enter: cherry-pick  n/Esc: cancel")
+	t.showPopup("confirm", "Cherry-pick", text.String())
+	return nil
+}
+
+// startCherryPick switches to branch2 if needed and applies the commits one at a time
+func (t *TUI) startCherryPick(g *gocui.Gui, v *gocui.View) error {
+	commits, err := t.markedCommits()
+	if err != nil {
+		t.setStatus("Error: %v", err)
+		return t.closePopup(g, v)
+	}
+	if err := checkCleanWorktree(); err != nil {
+		t.setStatus("Error: %v", err)
+		return t.closePopup(g, v)
+	}
+	if currentBranch() != t.branch2 {
+		status := runGitStatus([]string{"switch", t.branch2}, "")
+		if status != "" {
+			t.setStatus("%s", status)
+			return t.closePopup(g, v)
+		}
+	}
+	t.pick = &pickRun{queue: commits}
+	t.pickNext()
+	return nil
+}
+
+// currentBranch returns the branch HEAD is on, or "" when it is detached
+func currentBranch() string {
+	head, _ := exec.Command("git", "symbolic-ref", "--short", "-q", "HEAD").Output()
+	return strings.TrimSpace(string(head))
+}
+
+// checkCleanWorktree refuses to cherry-pick over uncommitted changes, which switching
+// branches would carry along and the picks would mix with
+func checkCleanWorktree() error {
+	output, err := exec.Command("git", "status", "--porcelain").Output()
+	if err != nil {
+		return fmt.Errorf("failed to check the working tree: %v", err)
+	}
+	if len(strings.TrimSpace(string(output))) > 0 {
+		return fmt.Errorf("the working tree has uncommitted changes; commit or stash them first")
+	}
+	return nil
+}
+
+// pickNext applies the next queued commit in the background, or finishes the run
+func (t *TUI) pickNext() {
+	pick := t.pick
+	if pick.pos >= len(pick.queue) {
+		t.pick = nil
+		t.popup = nil
+		t.gui.SetCurrentView("list")
+		status := fmt.Sprintf("Cherry-picked %d commit(s) onto %s", len(pick.queue)-pick.skipped, t.branch2)
+		if pick.skipped > 0 {
+			status += fmt.Sprintf(", skipped %d", pick.skipped)
+		}
+		t.setStatus("%s", status)
+		t.refreshList()
+		return
+	}
+	commit := pick.queue[pick.pos]
+	t.showPopup("progress", "Cherry-pick", fmt.Sprintf("[%d/%d] %s %s",
+		pick.pos+1, len(pick.queue), commit.Hash[:8], commit.Subject))
+	t.runPickCommand([]string{"cherry-pick", "-x", commit.Hash})
+}
+
+// runPickCommand runs a cherry-pick step without blocking the interface and moves
+// on to the next commit if it succeeds
+func (t *TUI) runPickCommand(args []string) {
+	t.pick.running = true
+	go func() {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		t.gui.Update(func(g *gocui.Gui) error {
+			pick := t.pick
+			pick.running = false
+			if err != nil {
+				pick.output = strings.TrimSpace(string(output))
+				if pick.output == "" {
+					pick.output = err.Error()
+				}
+				t.showConflict()
+				return nil
+			}
+			hash := pick.queue[pick.pos].Hash
+			t.applied[hash] = true
+			delete(t.marked, hash)
+			pick.pos++
+			t.refreshList()
+			t.pickNext()
+			return nil
+		})
+	}()
+}
+
+// showConflict shows why the current commit failed to apply and how to go on
+func (t *TUI) showConflict() {
+	pick := t.pick
+	commit := pick.queue[pick.pos]
+	var text strings.Builder
+	fmt.Fprintf(&text, "Failed to cherry-pick %s %s (%d of %d)\n\n%s\n",
+		commit.Hash[:8], commit.Subject, pick.pos+1, len(pick.queue), pick.output)
+	if files, _ := exec.Command("git", "diff", "--name-only", "--diff-filter=U").Output(); len(files) > 0 {
+		fmt.Fprint(&text, "\nConflicted files:\n")
+		for _, file := range splitLines(files) {
+			fmt.Fprintf(&text, "  %s\n", file)
+		}
+	}
+	fmt.Fprint(&text, "\nResolve and stage the changes in another terminal, then\n")
+	fmt.Fprint(&text, "c: continue  s: skip commit  A: abort  Esc: leave as is")
+	t.showPopup("conflict", "Conflict", text.String())
+}
+
+func (t *TUI) continueCherryPick(g *gocui.Gui, v *gocui.View) error {
+	if t.pick == nil || t.pick.running {
+		return nil
+	}
+	t.showPopup("progress", "Cherry-pick", "Continuing...")
+	t.runPickCommand([]string{"-c", "core.editor=true", "cherry-pick", "--continue"})
+	return nil
+}
+
+func (t *TUI) skipCherryPick(g *gocui.Gui, v *gocui.View) error {
+	if t.pick == nil || t.pick.running {
+		return nil
+	}
+	if status := runGitStatus([]string{"cherry-pick", "--skip"}, ""); status != "" {
+		t.pick.output = status
+		t.showConflict()
+		return nil
+	}
+	t.pick.pos++
+	t.pick.skipped++
+	t.pickNext()
+	return nil
+}
+
+// abortCherryPick undoes the failed commit; commits applied before it are kept
+func (t *TUI) abortCherryPick(g *gocui.Gui, v *gocui.View) error {
+	if t.pick == nil || t.pick.running {
+		return nil
+	}
+	applied := t.pick.pos
+	t.pick = nil
+	t.setStatus("%s", runGitStatus([]string{"cherry-pick", "--abort"},
+		fmt.Sprintf("Cherry-pick aborted; %d commit(s) applied to %s", applied, t.branch2)))
+	return t.closePopup(g, v)
+}
+
+// leaveConflict returns to the list with the cherry-pick stopped; c shows the conflict again
+func (t *TUI) leaveConflict(g *gocui.Gui, v *gocui.View) error {
+	t.setStatus("Cherry-pick stopped on %s; c: resume", t.pick.queue[t.pick.pos].Hash[:8])
+	return t.closePopup(g, v)
+}
+
+// exportCommand prints a cherry-pick command line for the marked commits on exit
+func (t *TUI) exportCommand(g *gocui.Gui, v *gocui.View) error {
+	commits, err := t.markedCommits()
+	if err != nil {
+		t.setStatus("Error: %v", err)
+		return nil
+	}
+	if len(commits) == 0 {
+		t.setStatus("No commits to export")
+		return nil
+	}
+	args := []string{"git", "cherry-pick", "-x"}
+	for _, commit := range commits {
+		args = append(args, commit.Hash)
+	}
+	command := strings.Join(args, " ")
+	t.output = append(t.output, command)
+	t.setStatus("Printed on exit: %s", command)
+	return nil
+}
+
+// exportPatches writes the marked commits as a numbered patch series into
+// patches-<branch2> in the current directory
+func (t *TUI) exportPatches(g *gocui.Gui, v *gocui.View) error {
+	commits, err := t.markedCommits()
+	if err != nil {
+		t.setStatus("Error: %v", err)
+		return nil
+	}
+	if len(commits) == 0 {
+		t.setStatus("No commits to export")
+		return nil
+	}
+	dir := "patches-" + strings.ReplaceAll(t.branch2, "/", "-")
+	for i, commit := range commits {
+		args := []string{"format-patch", "--quiet", "-o", dir, "--start-number", fmt.Sprint(i + 1), "-1", commit.Hash}
+		if status := runGitStatus(args, ""); status != "" {
+			t.setStatus("%s", status)
+			return nil
+		}
+	}
+	path, _ := filepath.Abs(dir)
+	t.setStatus("Wrote %d patch(es) to %s", len(commits), path)
+	return nil
+}
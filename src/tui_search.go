@@ -0,0 +1,428 @@
+package gittools
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jroimartin/gocui"
+)
+
+// prompt is a search or filter being typed in the status line
+type prompt struct {
+	label   string             // shown in front of the input, e.g. "/"
+	changed func(string) error // applies the input after every edit
+	cancel  func()             // restores the state from before the prompt
+	done    func()             // called when the input is accepted with Enter
+	move    func(int)          // moves the selection of a list shown above the prompt, if any
+	initial string             // text the input starts with
+	err     error              // why the input was not accepted
+}
+
+// text returns what the status line shows in front of the input
+func (p *prompt) text() string {
+	if p.err != nil {
+		return fmt.Sprintf("Error: %v; %s", p.err, p.label)
+	}
+	return p.label
+}
+
+// commitFilter narrows the commit list. It is parsed from the filter prompt, where
+// plain words must all occur in the subject and author:, date: and path: terms
+// select the author, a date range (2024-01-01..2024-02-01, either end optional)
+// and a path the commit touches.
+type commitFilter struct {
+	words        []string
+	author       string
+	since, until string   // inclusive, YYYY-MM-DD
+	paths        []string // pathspecs
+}
+
+func parseCommitFilter(text string) (commitFilter, error) {
+	var f commitFilter
+	for _, term := range strings.Fields(text) {
+		key, value, ok := strings.Cut(term, ":")
+		switch {
+		case ok && key == "author":
+			f.author = strings.ToLower(value)
+		case ok && key == "path":
+			if value != "" {
+				f.paths = append(f.paths, value)
+			}
+		case ok && key == "date":
+			since, until, isRange := strings.Cut(value, "..")
+			if !isRange {
+				until = since
+			}
+			for _, date := range []string{since, until} {
+				if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+					return f, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", date)
+				}
+			}
+			f.since, f.until = since, until
+		default:
+			f.words = append(f.words, strings.ToLower(term))
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether the filter selects a commit; touching holds the commits
+// touching the filtered paths
+func (f commitFilter) matches(commit Commit, touching map[string]bool) bool {
+	subject := strings.ToLower(commit.Subject)
+	for _, word := range f.words {
+		if !strings.Contains(subject, word) {
+			return false
+		}
+	}
+	switch {
+	case f.author != "" && !strings.Contains(strings.ToLower(commit.Author), f.author),
+		f.since != "" && commit.Date < f.since,
+		f.until != "" && commit.Date > f.until,
+		len(f.paths) > 0 && !touching[commit.Hash]:
+		return false
+	}
+	return true
+}
+
+// setSearchKeybindings binds the keys of the search and filter prompt
+func (t *TUI) setSearchKeybindings() error {
+	bindings := []struct {
+		view    string
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}{
+		{"prompt", gocui.KeyEnter, t.acceptPrompt},
+		{"prompt", gocui.KeyEsc, t.cancelPrompt},
+		{"prompt", gocui.KeyArrowUp, t.movePrompt(-1)},
+		{"prompt", gocui.KeyArrowDown, t.movePrompt(1)},
+		{"prompt", gocui.KeyCtrlP, t.movePrompt(-1)},
+		{"prompt", gocui.KeyCtrlN, t.movePrompt(1)},
+	}
+	for _, b := range bindings {
+		if err := t.gui.SetKeybinding(b.view, b.key, gocui.ModNone, b.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// highlighter marks the searched text and the filtered subject words
+func (t *TUI) highlighter() *highlighter {
+	var patterns []string
+	if t.search != "" {
+		patterns = append(patterns, t.search)
+	}
+	if filter, err := parseCommitFilter(t.filter); err == nil {
+		patterns = append(patterns, filter.words...)
+	}
+	return newHighlighter(patterns, PatternFixed, true)
+}
+
+// layoutPrompt draws the input of an open prompt over the status line, after its label
+func (t *TUI) layoutPrompt(g *gocui.Gui) error {
+	if t.prompt == nil {
+		g.DeleteView("prompt")
+		return nil
+	}
+	maxX, maxY := g.Size()
+	v, err := g.SetView("prompt", len(t.prompt.text())-1, maxY-2, maxX, maxY)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Frame = false
+		v.Editable = true
+		v.Editor = gocui.EditorFunc(func(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
+			gocui.DefaultEditor.Edit(v, key, ch, mod)
+			if t.prompt != nil {
+				t.prompt.err = nil
+				t.prompt.changed(promptInput(v)) // errors are reported on Enter, not mid-word
+				t.renderStatus()
+			}
+		})
+		fmt.Fprint(v, t.prompt.initial)
+		v.SetCursor(len(t.prompt.initial), 0)
+	}
+	if _, err := g.SetViewOnTop("prompt"); err != nil {
+		return err
+	}
+	_, err = g.SetCurrentView("prompt")
+	return err
+}
+
+func promptInput(v *gocui.View) string {
+	return strings.TrimSpace(v.Buffer())
+}
+
+func (t *TUI) openPrompt(p *prompt) {
+	t.prompt = p
+	t.status = ""
+	t.renderStatus()
+}
+
+func (t *TUI) closePrompt(g *gocui.Gui) error {
+	t.prompt = nil
+	g.DeleteView("prompt")
+	t.renderStatus()
+	_, err := g.SetCurrentView("list")
+	return err
+}
+
+// acceptPrompt closes the prompt unless its input is invalid
+func (t *TUI) acceptPrompt(g *gocui.Gui, v *gocui.View) error {
+	if err := t.prompt.changed(promptInput(v)); err != nil {
+		t.prompt.err = err
+		t.renderStatus()
+		return nil
+	}
+	done := t.prompt.done
+	if err := t.closePrompt(g); err != nil {
+		return err
+	}
+	done()
+	return nil
+}
+
+func (t *TUI) cancelPrompt(g *gocui.Gui, v *gocui.View) error {
+	cancel := t.prompt.cancel
+	if err := t.closePrompt(g); err != nil {
+		return err
+	}
+	cancel()
+	return nil
+}
+
+// movePrompt returns a handler moving the selection of the prompt's list
+func (t *TUI) movePrompt(delta int) func(*gocui.Gui, *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if t.prompt != nil && t.prompt.move != nil {
+			t.prompt.move(delta)
+		}
+		return nil
+	}
+}
+
+// selectCommit moves the cursor to the commit at index and shows it
+func (t *TUI) selectCommit(index int) {
+	t.current = index
+	if v, err := t.gui.View("list"); err == nil {
+		t.setCursor(v, index)
+	}
+	if v, err := t.gui.View("detail"); err == nil {
+		t.updateCommitDetail(v, index)
+	}
+}
+
+// redraw shows the commit list again after the commits or highlights changed
+func (t *TUI) redraw() {
+	if v, err := t.gui.View("list"); err == nil {
+		t.updateCommitList(v)
+	}
+	t.renderHeader()
+	t.renderStatus()
+	t.selectCommit(t.current)
+}
+
+// startSearch jumps to the first commit matching the search text as it is typed;
+// Escape returns to where the search started
+func (t *TUI) startSearch(g *gocui.Gui, v *gocui.View) error {
+	start, previous := t.current, t.search
+	t.openPrompt(&prompt{
+		label: "/",
+		changed: func(text string) error {
+			t.search = text
+			t.current = start
+			if i, ok := t.findMatch(start, 1); ok {
+				t.current = i
+			}
+			t.redraw()
+			return nil
+		},
+		cancel: func() {
+			t.search = previous
+			t.current = start
+			t.redraw()
+		},
+		done: func() {
+			if t.search != "" {
+				t.setStatus("%d commit(s) match '%s'; n/N: next/previous", len(t.searchMatches()), t.search)
+			}
+		},
+	})
+	return nil
+}
+
+// searchMatches returns the indexes of the listed commits matching the search by
+// subject, author or hash prefix
+func (t *TUI) searchMatches() []int {
+	if t.search == "" {
+		return nil
+	}
+	h := newHighlighter([]string{t.search}, PatternFixed, true)
+	var matches []int
+	for i, commit := range t.commits {
+		if h.matches(commit.Subject) || h.matches(commit.Author) || strings.HasPrefix(commit.Hash, strings.ToLower(t.search)) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// findMatch returns the first match at or after from (direction 1) or at or before
+// it (direction -1), wrapping around the list
+func (t *TUI) findMatch(from, direction int) (int, bool) {
+	matches := t.searchMatches()
+	if len(matches) == 0 {
+		return 0, false
+	}
+	if direction > 0 {
+		for _, i := range matches {
+			if i >= from {
+				return i, true
+			}
+		}
+		return matches[0], true
+	}
+	for j := len(matches) - 1; j >= 0; j-- {
+		if matches[j] <= from {
+			return matches[j], true
+		}
+	}
+	return matches[len(matches)-1], true
+}
+
+func (t *TUI) nextMatch(g *gocui.Gui, v *gocui.View) error {
+	return t.jumpToMatch(1)
+}
+
+func (t *TUI) previousMatch(g *gocui.Gui, v *gocui.View) error {
+	return t.jumpToMatch(-1)
+}
+
+func (t *TUI) jumpToMatch(direction int) error {
+	if t.search == "" {
+		t.setStatus("No search; press / to search")
+		return nil
+	}
+	i, ok := t.findMatch(t.current+direction, direction)
+	if !ok {
+		t.setStatus("No commits match '%s'", t.search)
+		return nil
+	}
+	if t.visual >= 0 {
+		defer t.refreshList()
+	}
+	t.selectCommit(i)
+	for n, match := range t.searchMatches() {
+		if match == i {
+			t.setStatus("Match %d of %d for '%s'", n+1, len(t.searchMatches()), t.search)
+		}
+	}
+	return nil
+}
+
+// startFilter narrows the list as the filter is typed; Escape restores the previous
+// filter. Listing the commits touching a path runs git, so path: terms only apply on
+// Enter unless they were used before.
+func (t *TUI) startFilter(g *gocui.Gui, v *gocui.View) error {
+	previous := t.filter
+	var input string
+	t.openPrompt(&prompt{
+		label:   "filter (words, author:, date:FROM..TO, path: on Enter): ",
+		initial: t.filter,
+		changed: func(text string) error {
+			input = text
+			return t.previewFilter(text)
+		},
+		cancel: func() {
+			t.applyFilter(previous)
+		},
+		done: func() {
+			if err := t.applyFilter(input); err != nil {
+				t.setStatus("Error: %v", err)
+			} else if t.filter != "" {
+				t.setStatus("Showing %d of %d commits; f: change filter", len(t.commits), len(t.all))
+			}
+		},
+	})
+	return nil
+}
+
+// previewFilter narrows the list while the filter is typed, leaving out the path
+// terms whose commits are not known yet
+func (t *TUI) previewFilter(text string) error {
+	filter, err := parseCommitFilter(text)
+	if err != nil {
+		return err
+	}
+	touching, ok := t.pathCache[strings.Join(filter.paths, "\x00")]
+	if len(filter.paths) > 0 && !ok {
+		filter.paths = nil
+	}
+	t.showFiltered(text, filter, touching)
+	return nil
+}
+
+// applyFilter narrows the list to the commits matching text, keeping the cursor
+// on the same commit if it is still listed
+func (t *TUI) applyFilter(text string) error {
+	filter, err := parseCommitFilter(text)
+	if err != nil {
+		return err
+	}
+	var touching map[string]bool
+	if len(filter.paths) > 0 {
+		if touching, err = t.commitsTouching(filter.paths); err != nil {
+			return err
+		}
+	}
+	t.showFiltered(text, filter, touching)
+	return nil
+}
+
+// showFiltered lists the commits matching filter, parsed from text
+func (t *TUI) showFiltered(text string, filter commitFilter, touching map[string]bool) {
+	var current string
+	if t.current < len(t.commits) {
+		current = t.commits[t.current].Hash
+	}
+	t.filter = text
+	t.commits = nil
+	t.current = 0
+	for _, commit := range t.all {
+		if filter.matches(commit, touching) {
+			if commit.Hash == current {
+				t.current = len(t.commits)
+			}
+			t.commits = append(t.commits, commit)
+		}
+	}
+	t.visual = -1 // indexes changed
+	t.status = ""
+	t.redraw()
+}
+
+// commitsTouching returns the missing commits changing any of the paths
+func (t *TUI) commitsTouching(paths []string) (map[string]bool, error) {
+	key := strings.Join(paths, "\x00")
+	if touching, ok := t.pathCache[key]; ok {
+		return touching, nil
+	}
+	args := append([]string{"rev-list", t.branch1, "--not", t.branch2, "--"}, paths...)
+	output, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits touching %s: %v", strings.Join(paths, ", "), err)
+	}
+	touching := make(map[string]bool)
+	for _, hash := range strings.Fields(string(output)) {
+		touching[hash] = true
+	}
+	if t.pathCache == nil {
+		t.pathCache = make(map[string]map[string]bool)
+	}
+	t.pathCache[key] = touching
+	return touching, nil
+}
@@ -0,0 +1,182 @@
+package gittools
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// style is how the TUI draws a span of text: a gocui color, optionally combined with
+// AttrBold, AttrUnderline or AttrReverse, on a background color
+type style struct {
+	fg, bg gocui.Attribute
+}
+
+const colorMask gocui.Attribute = 0xff // the color bits of an attribute
+
+// sgr returns the escape sequence gocui's OutputNormal mode draws as s. It starts with
+// a reset and gives the color before the attributes: gocui drops attributes that
+// come before a color, so git's "1;32" shows as plain green.
+func (s style) sgr() string {
+	params := []string{"0"}
+	if color := s.fg & colorMask; color != gocui.ColorDefault {
+		params = append(params, strconv.Itoa(30+int(color)-1))
+	}
+	if color := s.bg & colorMask; color != gocui.ColorDefault {
+		params = append(params, strconv.Itoa(40+int(color)-1))
+	}
+	for _, attr := range styleAttributes {
+		if s.fg&attr.attr != 0 {
+			params = append(params, attr.code)
+		}
+	}
+	return "\033[" + strings.Join(params, ";") + "m"
+}
+
+// paint returns text drawn in s
+func (s style) paint(text string) string {
+	return s.sgr() + text + ColorReset
+}
+
+var styleAttributes = []struct {
+	name string
+	attr gocui.Attribute
+	code string
+}{
+	{"bold", gocui.AttrBold, "1"},
+	{"underline", gocui.AttrUnderline, "4"},
+	{"reverse", gocui.AttrReverse, "7"},
+}
+
+var styleColors = map[string]gocui.Attribute{
+	"default": gocui.ColorDefault,
+	"black":   gocui.ColorBlack,
+	"red":     gocui.ColorRed,
+	"green":   gocui.ColorGreen,
+	"yellow":  gocui.ColorYellow,
+	"blue":    gocui.ColorBlue,
+	"magenta": gocui.ColorMagenta,
+	"cyan":    gocui.ColorCyan,
+	"white":   gocui.ColorWhite,
+}
+
+// parseStyle reads a style such as "bold yellow" or "black on green"
+func parseStyle(spec string) (style, error) {
+	var s style
+	color := &s.fg
+	for _, word := range strings.Fields(strings.ToLower(spec)) {
+		if word == "on" && color == &s.fg {
+			color = &s.bg
+			continue
+		}
+		if c, ok := styleColors[word]; ok {
+			*color = *color&^colorMask | c
+			continue
+		}
+		found := false
+		for _, attr := range styleAttributes {
+			if word == attr.name {
+				s.fg |= attr.attr
+				found = true
+			}
+		}
+		if !found {
+			return s, fmt.Errorf("invalid style '%s': unknown word '%s'", spec, word)
+		}
+	}
+	return s, nil
+}
+
+// theme holds the styles of the TUIs
+type theme struct {
+	selection, header             style // selected line of the lists and the title line
+	commit, meta, hunk            style // commit line, file headers and hunk headers of patches
+	added, removed                style // changed lines; changed words are also reversed
+	keyword, str, comment, number style // syntax coloring of code
+	note, heading, command        style // notes above the patch and the cherry-pick command below
+}
+
+// roles maps the names used by theme.<role> settings to the styles of th
+func (th *theme) roles() map[string]*style {
+	return map[string]*style{
+		"selection": &th.selection, "header": &th.header,
+		"commit": &th.commit, "meta": &th.meta, "hunk": &th.hunk,
+		"added": &th.added, "removed": &th.removed,
+		"keyword": &th.keyword, "string": &th.str, "comment": &th.comment, "number": &th.number,
+		"note": &th.note, "heading": &th.heading, "command": &th.command,
+	}
+}
+
+// warning returns the style marking differences that need attention: the removed
+// style in bold
+func (th theme) warning() style {
+	return style{fg: th.removed.fg | gocui.AttrBold, bg: th.removed.bg}
+}
+
+// themePresets are the themes selected by tui.theme, by role
+var themePresets = map[string]map[string]string{
+	"dark": {
+		"selection": "black on green", "header": "bold",
+		"commit": "yellow", "meta": "bold", "hunk": "cyan",
+		"added": "green", "removed": "red",
+		"keyword": "magenta", "string": "yellow", "comment": "blue", "number": "cyan",
+		"note": "yellow", "heading": "bold cyan", "command": "bold green",
+	},
+	"light": {
+		"selection": "white on blue", "header": "bold blue",
+		"commit": "bold magenta", "meta": "bold", "hunk": "blue",
+		"added": "green", "removed": "red",
+		"keyword": "blue", "string": "magenta", "comment": "cyan", "number": "magenta",
+		"note": "magenta", "heading": "bold blue", "command": "bold green",
+	},
+	"high-contrast": {
+		"selection": "black on white", "header": "bold white",
+		"commit": "bold yellow", "meta": "bold white", "hunk": "bold cyan",
+		"added": "bold green", "removed": "bold red",
+		"keyword": "bold magenta", "string": "bold yellow", "comment": "cyan", "number": "bold cyan",
+		"note": "bold yellow", "heading": "bold white", "command": "bold green",
+	},
+}
+
+// newTheme returns a preset with the given role styles replaced
+func newTheme(preset string, overrides map[string]string) (theme, error) {
+	var th theme
+	styles, ok := themePresets[preset]
+	if !ok {
+		names := make([]string, 0, len(themePresets))
+		for name := range themePresets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return th, fmt.Errorf("unknown theme '%s' (expected %s)", preset, strings.Join(names, ", "))
+	}
+	roles := th.roles()
+	for _, specs := range []map[string]string{styles, overrides} {
+		for role, spec := range specs {
+			target, ok := roles[role]
+			if !ok {
+				return th, fmt.Errorf("unknown theme role '%s'", role)
+			}
+			s, err := parseStyle(spec)
+			if err != nil {
+				return th, fmt.Errorf("theme.%s: %v", role, err)
+			}
+			*target = s
+		}
+	}
+	return th, nil
+}
+
+// loadTheme reads tui.theme and the theme.<role> overrides
+func loadTheme(cfg *Config) (theme, error) {
+	overrides := make(map[string]string)
+	for _, key := range cfg.Keys() {
+		if role, ok := strings.CutPrefix(key, "theme."); ok {
+			overrides[role] = cfg.Get(key)
+		}
+	}
+	return newTheme(cfg.Get("tui.theme"), overrides)
+}
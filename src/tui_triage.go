@@ -0,0 +1,176 @@
+package gittools
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+// parseTriageStatus reads a triage status as typed: skip, needs-backport, backported
+// and the commit it was ported as, or none; unambiguous prefixes are accepted
+func parseTriageStatus(text string) (status, commit string, err error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", "", nil
+	}
+	var matches []string
+	for _, name := range append(triageStatuses, "none") {
+		if strings.HasPrefix(name, fields[0]) {
+			matches = append(matches, name)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return "", "", fmt.Errorf("unknown status '%s' (expected %s or none)", fields[0], strings.Join(triageStatuses, ", "))
+	case len(matches) > 1:
+		return "", "", fmt.Errorf("'%s' is ambiguous (%s)", fields[0], strings.Join(matches, ", "))
+	}
+	status = matches[0]
+	switch {
+	case status == TriageBackported && len(fields) != 2:
+		return "", "", fmt.Errorf("expected '%s <commit>'", TriageBackported)
+	case status != TriageBackported && len(fields) != 1:
+		return "", "", fmt.Errorf("%s takes no commit", status)
+	case status == "none":
+		return "", "", nil
+	case status == TriageBackported:
+		commit = fields[1]
+	}
+	return status, commit, nil
+}
+
+// triageOf returns the decision on a listed commit
+func (t *TUI) triageOf(hash string) (Triage, bool) {
+	return t.triage.Get(t.branch2, hash)
+}
+
+// triageBadge returns the badge of a commit's triage decision in the commit list
+func (t *TUI) triageBadge(hash string) string {
+	triage, ok := t.triageOf(hash)
+	if !ok {
+		return ""
+	}
+	badge, s := triage.label(), t.theme.note
+	switch triage.Status {
+	case TriageSkip:
+		s = t.theme.comment
+	case TriageNeedsBackport:
+		s = t.theme.removed
+	case TriageBackported:
+		s = t.theme.added
+	case "":
+		badge = "note"
+	}
+	if triage.Status != "" && triage.Note != "" {
+		badge += " +note"
+	}
+	return s.paint("["+badge+"]") + " "
+}
+
+// startTriage sets the triage status of the selected commit
+func (t *TUI) startTriage(g *gocui.Gui, v *gocui.View) error {
+	if t.current >= len(t.commits) {
+		return nil
+	}
+	hash := t.commits[t.current].Hash
+	triage, _ := t.triageOf(hash)
+	initial := triage.Status
+	if triage.Commit != "" {
+		initial += " " + triage.Commit
+	}
+	var input string
+	t.openPrompt(&prompt{
+		label:   "triage (skip, needs-backport, backported <commit>, none): ",
+		initial: initial,
+		changed: func(text string) error {
+			input = text
+			_, _, err := parseTriageStatus(text)
+			return err
+		},
+		cancel: func() {},
+		done: func() {
+			if err := t.setTriageStatus(hash, input); err != nil {
+				t.setStatus("Error: %v", err)
+			}
+		},
+	})
+	return nil
+}
+
+// startNote sets the triage note of the selected commit
+func (t *TUI) startNote(g *gocui.Gui, v *gocui.View) error {
+	if t.current >= len(t.commits) {
+		return nil
+	}
+	hash := t.commits[t.current].Hash
+	triage, _ := t.triageOf(hash)
+	var input string
+	t.openPrompt(&prompt{
+		label:   "note (empty: remove): ",
+		initial: triage.Note,
+		changed: func(text string) error {
+			input = text
+			return nil
+		},
+		cancel: func() {},
+		done: func() {
+			if err := t.setTriageNote(hash, input); err != nil {
+				t.setStatus("Error: %v", err)
+			}
+		},
+	})
+	return nil
+}
+
+// setTriageStatus records the status typed for a commit, keeping its note
+func (t *TUI) setTriageStatus(hash, text string) error {
+	status, commit, err := parseTriageStatus(text)
+	if err != nil {
+		return err
+	}
+	if commit != "" {
+		output, err := exec.Command("git", "rev-parse", "--verify", "--quiet", commit+"^{commit}").Output()
+		if err != nil {
+			return fmt.Errorf("'%s' is not a commit", commit)
+		}
+		commit = strings.TrimSpace(string(output))
+	}
+	triage, _ := t.triageOf(hash)
+	triage.Status, triage.Commit = status, commit
+	if err := t.saveTriage(hash, triage); err != nil {
+		return err
+	}
+	if status == "" {
+		t.setStatus("Cleared the triage status of %s", hash[:8])
+	} else {
+		t.setStatus("Triaged %s as %s", hash[:8], triage.label())
+	}
+	return nil
+}
+
+// setTriageNote records the note typed for a commit, keeping its status
+func (t *TUI) setTriageNote(hash, note string) error {
+	triage, _ := t.triageOf(hash)
+	triage.Note = note
+	if err := t.saveTriage(hash, triage); err != nil {
+		return err
+	}
+	if note == "" {
+		t.setStatus("Removed the note of %s", hash[:8])
+	} else {
+		t.setStatus("Noted %s", hash[:8])
+	}
+	return nil
+}
+
+// saveTriage stores a decision and shows it in the list and the patch pane
+func (t *TUI) saveTriage(hash string, triage Triage) error {
+	if err := t.triage.Set(t.branch2, hash, triage); err != nil {
+		return err
+	}
+	t.refreshList()
+	t.rerenderPatch()
+	return nil
+}
@@ -8,13 +8,34 @@ type Commit struct {
 	Date    string
 }
 
+// Trailer is a single "Key: value" line from the trailer block of a commit message
+type Trailer struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+}
+
+// CommitInfo holds the per-commit data kept in the commit index cache
+type CommitInfo struct {
+	Hash     string    `json:"h"`
+	Subject  string    `json:"s"` // normalized subject
+	ChangeID string    `json:"c,omitempty"`
+	Trailers []Trailer `json:"t,omitempty"`
+	// PatchID is nil until computed; an empty string means the commit has no patch (e.g. merges)
+	PatchID *string `json:"p,omitempty"`
+}
+
 // Use ASCII unit separator (\x1f) as a safe delimiter for git log output
 const LogDelimiter = "\x1f"
 
+// RecordDelimiter (ASCII record separator) terminates multi-line git log records
+const RecordDelimiter = "\x1e"
+
 // ANSI color codes
 const (
-	ColorReset  = "\033[0m"
-	ColorYellow = "\033[33m"
-	ColorGreen  = "\033[32m"
-	ColorCyan   = "\033[36m"
+	ColorReset   = "\033[0m"
+	ColorRed     = "\033[31m"
+	ColorYellow  = "\033[33m"
+	ColorGreen   = "\033[32m"
+	ColorCyan    = "\033[36m"
+	ColorBoldRed = "\033[1;31m" // matched text, as in git grep
 ) 
\ No newline at end of file
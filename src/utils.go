@@ -2,6 +2,7 @@ package gittools
 
 import (
 	"os/exec"
+	"regexp"
 	"strings"
 	"unicode"
 )
@@ -38,4 +39,84 @@ func NormalizeSubject(subject string) string {
 	}
 	// Collapse all whitespace to single spaces
 	return strings.Join(strings.Fields(string(clean)), " ")
-} 
\ No newline at end of file
+}
+
+var (
+	trailerLineRe    = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*)\s*:\s*(.*)$`)
+	cherryPickLineRe = regexp.MustCompile(`^\(cherry picked from commit ([0-9a-f]+)\)$`)
+)
+
+// CherryPickedFromTrailer is the key ParseTrailers uses for "(cherry picked from commit X)" lines
+const CherryPickedFromTrailer = "Cherry-picked-from"
+
+// ParseTrailers extracts the trailers from the last paragraph of a commit message.
+// Continuation lines are unfolded and "(cherry picked from commit X)" lines added by
+// "git cherry-pick -x" are reported under CherryPickedFromTrailer.
+func ParseTrailers(body string) []Trailer {
+	paragraphs := strings.Split(strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n")), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil // a lone subject paragraph never carries trailers
+	}
+	var trailers []Trailer
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(trailers) > 0 {
+			last := &trailers[len(trailers)-1]
+			last.Value = strings.TrimSpace(last.Value + " " + strings.TrimSpace(line))
+			continue
+		}
+		if m := cherryPickLineRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			trailers = append(trailers, Trailer{Key: CherryPickedFromTrailer, Value: m[1]})
+			continue
+		}
+		m := trailerLineRe.FindStringSubmatch(line)
+		if m == nil {
+			return nil // not a trailer block
+		}
+		trailers = append(trailers, Trailer{Key: m[1], Value: strings.TrimSpace(m[2])})
+	}
+	return trailers
+}
+
+// TrailerValue returns the first value of the trailer with the given key (case-insensitive)
+func TrailerValue(trailers []Trailer, key string) string {
+	for _, t := range trailers {
+		if strings.EqualFold(t.Key, key) {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removeString returns list without any occurrence of s
+func removeString(list []string, s string) []string {
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// matchesAny reports whether any of the patterns matches s
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}